@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ArchiveGap      = time.Second    //wall clock gap between recorded segments is discontinuity
+	ArchiveMaxRange = 24 * time.Hour //max playlist window, one entry per segment
+)
+
+//recordRoot stream footage root
+func (element *ConfigST) recordRoot(uuid string) (string, error) {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	tmp, ok := element.Streams[uuid]
+	if !ok {
+		return "", ErrorStreamNotFound
+	}
+	return tmp.recordRoot(element.Server.RecordPath), nil
+}
+
+//Archive recorded segments overlap start end
+func (element *ConfigST) Archive(uuid string, start time.Time, end time.Time) ([]RecordFileST, error) {
+	if end.Sub(start) > ArchiveMaxRange {
+		return nil, ErrorStreamArchiveRange
+	}
+	root, err := element.recordRoot(uuid)
+	if err != nil {
+		return nil, err
+	}
+	files, err := recordScan(root, uuid, start, end)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrorStreamArchiveNotFound
+		}
+		return nil, err
+	}
+	var res []RecordFileST
+	for _, file := range files {
+		if file.Time.Add(file.Duration).After(start) && file.Time.Before(end) {
+			res = append(res, file)
+		}
+	}
+	if len(res) == 0 {
+		return nil, ErrorStreamArchiveNotFound
+	}
+	return res, nil
+}
+
+//ArchiveFile recorded segment or init path, name checked stay inside stream root
+func (element *ConfigST) ArchiveFile(uuid string, day string, name string) (string, error) {
+	root, err := element.recordRoot(uuid)
+	if err != nil {
+		return "", err
+	}
+	if _, err = time.Parse(RecordDateLayout, day); err != nil {
+		return "", ErrorStreamArchiveNotFound
+	}
+	if _, _, _, ok := parseRecordName(name); !ok && !(strings.HasPrefix(name, "init_") && strings.HasSuffix(name, ".mp4") && filepath.Base(name) == name) {
+		return "", ErrorStreamArchiveNotFound
+	}
+	return filepath.Join(root, uuid, day, name), nil
+}
+
+//ArchiveM3u8 VOD playlist, discontinuity on recording gap and init change
+func ArchiveM3u8(files []RecordFileST) string {
+	var target float64
+	for _, file := range files {
+		target = math.Max(target, file.Duration.Seconds())
+	}
+	var res strings.Builder
+	res.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:" + strconv.Itoa(int(math.Ceil(target))) + "\n")
+	res.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for i, file := range files {
+		if i > 0 {
+			prev := files[i-1]
+			gap := file.Time.Sub(prev.Time.Add(prev.Duration))
+			//same init in next day dir is same codecs
+			if filepath.Base(file.Init) != filepath.Base(prev.Init) || gap > ArchiveGap || gap < -ArchiveGap {
+				res.WriteString("#EXT-X-DISCONTINUITY\n")
+			}
+		}
+		if i == 0 || file.Init != files[i-1].Init {
+			res.WriteString("#EXT-X-MAP:URI=\"" + archiveURI(file.Init) + "\"\n")
+		}
+		res.WriteString("#EXT-X-PROGRAM-DATE-TIME:" + file.Time.UTC().Format("2006-01-02T15:04:05.000Z") + "\n")
+		res.WriteString("#EXTINF:" + strconv.FormatFloat(file.Duration.Seconds(), 'f', 3, 64) + ",\n")
+		res.WriteString(archiveURI(file.Path) + "\n")
+	}
+	res.WriteString("#EXT-X-ENDLIST\n")
+	return res.String()
+}
+
+//archiveURI playlist relative uri archive/day/name
+func archiveURI(path string) string {
+	return "archive/" + filepath.Base(filepath.Dir(path)) + "/" + filepath.Base(path)
+}
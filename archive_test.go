@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//testArchiveFile recorded file at offset from start in day dir
+func testArchiveFile(day string, offset time.Duration, duration time.Duration, init string) RecordFileST {
+	start := time.Date(2026, 10, 17, 23, 59, 56, 0, time.UTC).Add(offset)
+	return RecordFileST{
+		Path:     "/rec/cam/" + day + "/" + start.Format("150405") + ".m4s",
+		Init:     "/rec/cam/" + day + "/" + init,
+		Time:     start,
+		Duration: duration,
+	}
+}
+
+func TestArchiveM3u8(t *testing.T) {
+	files := []RecordFileST{
+		testArchiveFile("2026-10-17", 0, 2*time.Second, "init_a.mp4"),
+		testArchiveFile("2026-10-17", 2*time.Second, 2500*time.Millisecond, "init_a.mp4"),
+	}
+	res := ArchiveM3u8(files)
+	if !strings.HasPrefix(res, "#EXTM3U\n") || !strings.HasSuffix(res, "#EXT-X-ENDLIST\n") || !strings.Contains(res, "#EXT-X-TARGETDURATION:3\n") {
+		t.Errorf("playlist\n%s", res)
+	}
+	if strings.Count(res, "#EXT-X-MAP:") != 1 || strings.Contains(res, "#EXT-X-DISCONTINUITY") || strings.Count(res, "#EXTINF:") != 2 {
+		t.Errorf("contiguous\n%s", res)
+	}
+	for _, file := range files {
+		if !strings.Contains(res, "\n"+archiveURI(file.Path)+"\n") {
+			t.Errorf("uri missing %s", archiveURI(file.Path))
+		}
+	}
+}
+
+func TestArchiveM3u8Discontinuity(t *testing.T) {
+	//small overlap no break, gap and init change break, same init next day new map only
+	for name, want := range map[string][2]int{
+		"overlap": {1, 0},
+		"gap":     {1, 1},
+		"init":    {2, 1},
+		"day":     {2, 0},
+	} {
+		files := []RecordFileST{testArchiveFile("2026-10-17", 0, 2*time.Second, "init_a.mp4")}
+		switch name {
+		case "overlap":
+			files = append(files, testArchiveFile("2026-10-17", 1500*time.Millisecond, 2*time.Second, "init_a.mp4"))
+		case "gap":
+			files = append(files, testArchiveFile("2026-10-17", time.Minute, 2*time.Second, "init_a.mp4"))
+		case "init":
+			files = append(files, testArchiveFile("2026-10-17", 2*time.Second, 2*time.Second, "init_b.mp4"))
+		case "day":
+			files = append(files, testArchiveFile("2026-10-18", 2*time.Second, 2*time.Second, "init_a.mp4"))
+		}
+		res := ArchiveM3u8(files)
+		if maps, discontinuity := strings.Count(res, "#EXT-X-MAP:"), strings.Count(res, "#EXT-X-DISCONTINUITY\n"); maps != want[0] || discontinuity != want[1] {
+			t.Errorf("%s maps %d discontinuity %d\n%s", name, maps, discontinuity, res)
+		}
+	}
+}
+
+func TestArchiveMaxRange(t *testing.T) {
+	config := &ConfigST{Streams: map[string]StreamST{"cam": {RecordPath: t.TempDir()}}}
+	now := time.Now()
+	if _, err := config.Archive("cam", now.Add(-time.Hour), now); err != ErrorStreamArchiveNotFound {
+		t.Errorf("inside range %v", err)
+	}
+	if _, err := config.Archive("cam", now.Add(-ArchiveMaxRange-time.Second), now); err != ErrorStreamArchiveRange {
+		t.Errorf("over range %v", err)
+	}
+}
+
+func TestArchiveAuth(t *testing.T) {
+	Config.mutex.Lock()
+	old := Config.Server
+	Config.mutex.Unlock()
+	t.Cleanup(func() {
+		Config.mutex.Lock()
+		Config.Server = old
+		Config.mutex.Unlock()
+	})
+	router := newRouter()
+	request := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/play/hls/test-missing/archive.m3u8?start=1792300000", nil)
+		req.RemoteAddr = "10.0.0.1:40000"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res.Code
+	}
+	//no auth configured remote client forbidden
+	if code := request(""); code != http.StatusForbidden {
+		t.Errorf("remote %d", code)
+	}
+	Config.mutex.Lock()
+	Config.Server.APIToken = "token"
+	Config.mutex.Unlock()
+	if code := request(""); code != http.StatusUnauthorized {
+		t.Errorf("no token %d", code)
+	}
+	//authorized reach handler, stream missing
+	if code := request("token"); code != http.StatusNotFound {
+		t.Errorf("token %d", code)
+	}
+	Config.mutex.Lock()
+	Config.Server.ArchivePublic = true
+	Config.mutex.Unlock()
+	if code := request(""); code != http.StatusNotFound {
+		t.Errorf("public %d", code)
+	}
+}
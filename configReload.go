@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+//ConfigWatchInterval config file poll interval
+const ConfigWatchInterval = 2 * time.Second
+
+//ReloadConfig read config file apply stream diff, invalid file keep current config
+func (element *ConfigST) ReloadConfig() error {
+	tmp, err := readConfig()
+	if err != nil {
+		log.Println("Config Reload Rejected Keep Current", err)
+		return err
+	}
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	//webhooks and api auth apply live other server options need restart
+	server := tmp.Server
+	tmp.Server.Webhooks, tmp.Server.APIToken, tmp.Server.APIUser, tmp.Server.APIPassword = element.Server.Webhooks, element.Server.APIToken, element.Server.APIUser, element.Server.APIPassword
+	tmp.Server.ArchivePublic = element.Server.ArchivePublic
+	if !jsonEqual(tmp.Server, element.Server) {
+		log.Println("Config Reload Server Options Need Restart")
+	}
+	element.Server.Webhooks, element.Server.APIToken, element.Server.APIUser, element.Server.APIPassword = server.Webhooks, server.APIToken, server.APIUser, server.APIPassword
+	element.Server.ArchivePublic = server.ArchivePublic
+	for uuid := range element.Streams {
+		if _, ok := tmp.Streams[uuid]; !ok {
+			log.Println(uuid, "Config Reload Stream Removed")
+			element.streamDelete(uuid)
+		}
+	}
+	for uuid, val := range tmp.Streams {
+		old, ok := element.Streams[uuid]
+		switch {
+		case !ok:
+			log.Println(uuid, "Config Reload Stream Added")
+			element.streamAdd(uuid, val)
+		case !old.equal(val):
+			//source, audio, fps or hls change reconnect, other fields apply in place
+			log.Println(uuid, "Config Reload Stream Changed")
+			element.streamEdit(uuid, val, old.hlsChanged(val))
+		}
+	}
+	return nil
+}
+
+//equal config fields equal runtime state ignored
+func (element *StreamST) equal(val StreamST) bool {
+	return jsonEqual(element, val)
+}
+
+//jsonEqual compare saved form of config values
+func jsonEqual(a interface{}, b interface{}) bool {
+	x, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	y, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(x) == string(y)
+}
+
+//ownWrite file content is last saveConfig write, api change already applied
+func (element *ConfigST) ownWrite(data []byte) bool {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	return sha256.Sum256(data) == element.saved
+}
+
+//watchConfig poll config file reload on change
+func (element *ConfigST) watchConfig() {
+	var modTime time.Time
+	var size int64
+	if info, err := os.Stat(ConfigFile); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+	for {
+		time.Sleep(ConfigWatchInterval)
+		info, err := os.Stat(ConfigFile)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().Equal(modTime) && info.Size() == size {
+			continue
+		}
+		modTime, size = info.ModTime(), info.Size()
+		data, err := ioutil.ReadFile(ConfigFile)
+		if err != nil || element.ownWrite(data) {
+			continue
+		}
+		log.Println("Config File Changed Reload")
+		element.ReloadConfig()
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestReloadConfigDiff(t *testing.T) {
+	name := testConfigFile(t, `{"server": {}, "streams": {
+  "idle": {"url": "rtsp://127.0.0.1:1/a", "on_demand": true},
+  "hls": {"url": "rtsp://127.0.0.1:1/b", "on_demand": true},
+  "source": {"url": "rtsp://127.0.0.1:1/c", "on_demand": true},
+  "same": {"url": "rtsp://127.0.0.1:1/d", "on_demand": true},
+  "removed": {"url": "rtsp://127.0.0.1:1/e", "on_demand": true}
+}}`)
+	element := &ConfigST{Streams: make(map[string]StreamST)}
+	if err := element.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	old := make(map[string]*Supervisor)
+	for uuid, val := range element.Streams {
+		old[uuid] = val.Supervisor
+	}
+	if len(old) != 5 {
+		t.Fatalf("added %d", len(old))
+	}
+	if err := ioutil.WriteFile(name, []byte(`{"server": {}, "streams": {
+  "idle": {"url": "rtsp://127.0.0.1:1/a", "on_demand": true, "on_demand_idle": 60, "record_retention_days": 3},
+  "hls": {"url": "rtsp://127.0.0.1:1/b", "on_demand": true, "hls_part_target_ms": 300},
+  "source": {"url": "rtsp://127.0.0.1:1/x", "on_demand": true},
+  "same": {"url": "rtsp://127.0.0.1:1/d", "on_demand": true},
+  "added": {"url": "rtsp://127.0.0.1:1/f", "on_demand": true}
+}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := element.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := element.Streams["removed"]; ok {
+		t.Error("removed stream kept")
+	}
+	if _, ok := element.Streams["added"]; !ok {
+		t.Error("added stream missing")
+	}
+	//reconnect queued only for source and hls change, same supervisor kept
+	for uuid, want := range map[string]int{"idle": 0, "hls": 1, "source": 1, "same": 0} {
+		val := element.Streams[uuid]
+		if val.Supervisor != old[uuid] || len(val.Supervisor.reconnect) != want {
+			t.Errorf("%s reconnect %d want %d", uuid, len(val.Supervisor.reconnect), want)
+		}
+	}
+	if val := element.Streams["idle"]; val.OnDemandIdle != 60 || val.RecordRetentionDays != 3 {
+		t.Errorf("in place %d %d", val.OnDemandIdle, val.RecordRetentionDays)
+	}
+	//invalid file keep current config
+	if err := ioutil.WriteFile(name, []byte(`{"streams": {"idle": {"url": "rtsp://127.0.0.1:1/a", "hls_segment_min_duration": -1}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := element.ReloadConfig(); err == nil || len(element.Streams) != 5 {
+		t.Errorf("invalid reload %v streams %d", err, len(element.Streams))
+	}
+}
+
+func TestConfigOwnWrite(t *testing.T) {
+	name := testConfigFile(t, "{\n  \"server\": {},\n  \"streams\": {}\n}\n")
+	element := &ConfigST{Streams: make(map[string]StreamST)}
+	element.mutex.Lock()
+	err := element.saveConfig("cam1", &StreamST{URL: "rtsp://127.0.0.1:1/a", OnDemand: true})
+	element.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !element.ownWrite(data) {
+		t.Error("own write reloaded")
+	}
+	//hand edit after save reload
+	data = []byte(strings.Replace(string(data), "rtsp://127.0.0.1:1/a", "rtsp://127.0.0.1:1/b", 1))
+	if err = ioutil.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if element.ownWrite(data) {
+		t.Error("hand edit skipped")
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+//jsonMemberST object member offsets in config file
+type jsonMemberST struct {
+	Key        string
+	KeyStart   int
+	ValueStart int
+	ValueEnd   int
+}
+
+//saveConfig patch one stream object in config file, nil remove, call under lock
+//other streams, server, unknown keys, key order and indent stay as written
+func (element *ConfigST) saveConfig(uuid string, val *StreamST) error {
+	data, err := ioutil.ReadFile(ConfigFile)
+	if err != nil {
+		return err
+	}
+	if data, err = configPatch(data, uuid, val); err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(ConfigFile); err == nil {
+		mode = info.Mode()
+	}
+	//temp in same dir rename never cross device
+	tmp, err := ioutil.TempFile(filepath.Dir(ConfigFile), filepath.Base(ConfigFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), ConfigFile); err != nil {
+		return err
+	}
+	element.saved = sha256.Sum256(data)
+	return nil
+}
+
+//configPatch config file with stream uuid set or removed
+func configPatch(data []byte, uuid string, val *StreamST) ([]byte, error) {
+	root := jsonSkip(data, 0)
+	members, end, err := jsonObject(data, root)
+	if err != nil {
+		return nil, err
+	}
+	//layout from top level keys, compact file stay compact
+	compact := !bytes.Contains(data[root:end], []byte("\n"))
+	var unit string
+	if len(members) > 0 {
+		unit = lineIndent(data, members[0].KeyStart)
+	}
+	streams, ok := jsonFind(members, "streams")
+	if !ok {
+		if val == nil {
+			return data, nil
+		}
+		if data, err = jsonPut(data, root, "streams", []byte("{}"), unit, compact); err != nil {
+			return nil, err
+		}
+		if members, _, err = jsonObject(data, root); err != nil {
+			return nil, err
+		}
+		streams, _ = jsonFind(members, "streams")
+	}
+	if val == nil {
+		return jsonDelete(data, streams.ValueStart, uuid)
+	}
+	value, err := jsonMarshal(val)
+	if err != nil {
+		return nil, err
+	}
+	old, _, err := jsonObject(data, streams.ValueStart)
+	if err != nil {
+		return nil, err
+	}
+	if member, ok := jsonFind(old, uuid); ok {
+		if value, err = jsonMerge(data[member.ValueStart:member.ValueEnd], value, streamKeys()); err != nil {
+			return nil, err
+		}
+	}
+	return jsonPut(data, streams.ValueStart, uuid, value, unit, compact)
+}
+
+//streamKeys json keys of StreamST, other keys in stream object kept on save
+func streamKeys() map[string]bool {
+	res := make(map[string]bool)
+	tmp := reflect.TypeOf(StreamST{})
+	for i := 0; i < tmp.NumField(); i++ {
+		if name := strings.Split(tmp.Field(i).Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+			res[name] = true
+		}
+	}
+	return res
+}
+
+//jsonMarshal compact json, urls keep & < > unescaped
+func jsonMarshal(val interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(val); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(buf.Bytes()), nil
+}
+
+//jsonObject members of object starting at start and offset of closing brace
+func jsonObject(data []byte, start int) ([]jsonMemberST, int, error) {
+	dec := json.NewDecoder(bytes.NewReader(data[start:]))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, 0, ErrorStreamConfigLayout
+	}
+	var res []jsonMemberST
+	for dec.More() {
+		keyStart := jsonSkip(data, start+int(dec.InputOffset()))
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, 0, err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, 0, ErrorStreamConfigLayout
+		}
+		var raw json.RawMessage
+		if err = dec.Decode(&raw); err != nil {
+			return nil, 0, err
+		}
+		end := start + int(dec.InputOffset())
+		res = append(res, jsonMemberST{Key: key, KeyStart: keyStart, ValueStart: end - len(raw), ValueEnd: end})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, 0, err
+	}
+	return res, start + int(dec.InputOffset()) - 1, nil
+}
+
+//jsonFind member by key
+func jsonFind(members []jsonMemberST, key string) (jsonMemberST, bool) {
+	for _, member := range members {
+		if member.Key == key {
+			return member, true
+		}
+	}
+	return jsonMemberST{}, false
+}
+
+//jsonMerge new object over old, order of old keys and keys not known kept
+func jsonMerge(old []byte, val []byte, known map[string]bool) ([]byte, error) {
+	oldMembers, _, err := jsonObject(old, jsonSkip(old, 0))
+	if err != nil {
+		//not an object replace
+		return val, nil
+	}
+	newMembers, _, err := jsonObject(val, 0)
+	if err != nil {
+		return nil, err
+	}
+	var res [][]byte
+	used := make(map[string]bool)
+	for _, member := range oldMembers {
+		if tmp, ok := jsonFind(newMembers, member.Key); ok {
+			res = append(res, val[tmp.KeyStart:tmp.ValueEnd])
+			used[member.Key] = true
+		} else if !known[member.Key] {
+			res = append(res, old[member.KeyStart:member.ValueEnd])
+		}
+	}
+	for _, member := range newMembers {
+		if !used[member.Key] {
+			res = append(res, val[member.KeyStart:member.ValueEnd])
+		}
+	}
+	var buf bytes.Buffer
+	if err = json.Compact(&buf, append(append([]byte("{"), bytes.Join(res, []byte(","))...), '}')); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//jsonPut set key of object at start to compact value, insert after last member if missing
+func jsonPut(data []byte, start int, key string, value []byte, unit string, compact bool) ([]byte, error) {
+	members, end, err := jsonObject(data, start)
+	if err != nil {
+		return nil, err
+	}
+	if member, ok := jsonFind(members, key); ok {
+		return jsonSplice(data, member.ValueStart, member.ValueEnd, jsonFormat(value, lineIndent(data, member.KeyStart), unit, compact)), nil
+	}
+	name, err := jsonMarshal(key)
+	if err != nil {
+		return nil, err
+	}
+	if compact {
+		member := append(append(name, ':'), jsonFormat(value, "", "", true)...)
+		if len(members) == 0 {
+			return jsonSplice(data, start+1, end, member), nil
+		}
+		return jsonSplice(data, members[len(members)-1].ValueEnd, members[len(members)-1].ValueEnd, append([]byte(","), member...)), nil
+	}
+	if len(members) == 0 {
+		prefix := lineIndent(data, start)
+		member := append(append([]byte("\n"+prefix+unit), name...), ": "...)
+		member = append(append(member, jsonFormat(value, prefix+unit, unit, false)...), "\n"+prefix...)
+		return jsonSplice(data, start+1, end, member), nil
+	}
+	last := members[len(members)-1]
+	prefix := lineIndent(data, last.KeyStart)
+	member := append(append([]byte(",\n"+prefix), name...), ": "...)
+	return jsonSplice(data, last.ValueEnd, last.ValueEnd, append(member, jsonFormat(value, prefix, unit, false)...)), nil
+}
+
+//jsonDelete remove key of object at start with its separator
+func jsonDelete(data []byte, start int, key string) ([]byte, error) {
+	members, end, err := jsonObject(data, start)
+	if err != nil {
+		return nil, err
+	}
+	for i, member := range members {
+		if member.Key != key {
+			continue
+		}
+		switch {
+		case len(members) == 1:
+			return jsonSplice(data, start+1, end, nil), nil
+		case i == 0:
+			return jsonSplice(data, member.KeyStart, members[1].KeyStart, nil), nil
+		default:
+			return jsonSplice(data, members[i-1].ValueEnd, member.ValueEnd, nil), nil
+		}
+	}
+	return data, nil
+}
+
+//jsonFormat compact value indented for member at prefix
+func jsonFormat(value []byte, prefix string, unit string, compact bool) []byte {
+	var buf bytes.Buffer
+	if compact {
+		if json.Compact(&buf, value) != nil {
+			return value
+		}
+		return buf.Bytes()
+	}
+	if json.Indent(&buf, value, prefix, unit) != nil {
+		return value
+	}
+	return buf.Bytes()
+}
+
+//jsonSplice data with start to end replaced
+func jsonSplice(data []byte, start int, end int, val []byte) []byte {
+	res := make([]byte, 0, len(data)-(end-start)+len(val))
+	res = append(res, data[:start]...)
+	res = append(res, val...)
+	return append(res, data[end:]...)
+}
+
+//jsonSkip offset of next token after white space and comma
+func jsonSkip(data []byte, pos int) int {
+	for pos < len(data) && strings.IndexByte(" \t\r\n,", data[pos]) != -1 {
+		pos++
+	}
+	return pos
+}
+
+//lineIndent leading white space of line holding pos
+func lineIndent(data []byte, pos int) string {
+	start := bytes.LastIndexByte(data[:pos], '\n') + 1
+	end := start
+	for end < pos && (data[end] == ' ' || data[end] == '\t') {
+		end++
+	}
+	return string(data[start:end])
+}
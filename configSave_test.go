@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//4 space indent, unknown keys, own key order
+const testConfigLayout = `{
+    "comment": "keep me",
+    "streams": {
+        "cam1": {
+            "fps_mode": "fixed",
+            "url": "rtsp://10.0.0.1/a?x=1&y=2",
+            "note": "lobby",
+            "status": false,
+            "fps": 25,
+            "hls_segment_min_duration": 2,
+            "hls_segment_max_segments": 6
+        },
+        "cam2": {"url": "rtsp://10.0.0.2/b"}
+    },
+    "server": {"http_port": ":8083"}
+}
+`
+
+func TestConfigPatchLayout(t *testing.T) {
+	data, err := configPatch([]byte(testConfigLayout), "cam1", &StreamST{URL: "rtsp://10.0.0.1/a?x=1&y=2", FPSMode: "fixed", FPS: 30, HlsSegmentMinDuration: 2, HlsSegmentMaxSegments: 6, Failback: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//changed object only, old keys in place, new keys after
+	want := `{
+    "comment": "keep me",
+    "streams": {
+        "cam1": {
+            "fps_mode": "fixed",
+            "url": "rtsp://10.0.0.1/a?x=1&y=2",
+            "note": "lobby",
+            "status": false,
+            "fps": 30,
+            "hls_segment_min_duration": 2,
+            "hls_segment_max_segments": 6,
+            "failback": true,
+            "on_demand": false,
+            "on_demand_idle": 0,
+            "audio": false,
+            "fps_probe_time": 0,
+            "hls_part_target_ms": 0
+        },
+        "cam2": {"url": "rtsp://10.0.0.2/b"}
+    },
+    "server": {"http_port": ":8083"}
+}
+`
+	if string(data) != want {
+		t.Fatalf("edit\n%s", data)
+	}
+	data, err = configPatch([]byte(testConfigLayout), "cam3", &StreamST{URL: "rtsp://10.0.0.3/c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `{
+    "comment": "keep me",
+    "streams": {
+        "cam1": {
+            "fps_mode": "fixed",
+            "url": "rtsp://10.0.0.1/a?x=1&y=2",
+            "note": "lobby",
+            "status": false,
+            "fps": 25,
+            "hls_segment_min_duration": 2,
+            "hls_segment_max_segments": 6
+        },
+        "cam2": {"url": "rtsp://10.0.0.2/b"},
+        "cam3": {
+            "url": "rtsp://10.0.0.3/c",
+            "status": false,
+            "on_demand": false,
+            "on_demand_idle": 0,
+            "audio": false,
+            "fps_mode": "",
+            "fps_probe_time": 0,
+            "fps": 0,
+            "hls_segment_min_duration": 0,
+            "hls_segment_max_segments": 0,
+            "hls_part_target_ms": 0
+        }
+    },
+    "server": {"http_port": ":8083"}
+}
+`
+	if string(data) != want {
+		t.Fatalf("add\n%s", data)
+	}
+	//first, last and only member removed with separator
+	data, err = configPatch([]byte(testConfigLayout), "cam1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "{\n    \"comment\": \"keep me\",\n    \"streams\": {\n        \"cam2\": {\"url\": \"rtsp://10.0.0.2/b\"}\n    },\n    \"server\": {\"http_port\": \":8083\"}\n}\n" {
+		t.Fatalf("delete first\n%s", data)
+	}
+	if data, err = configPatch(data, "cam2", nil); err != nil || string(data) != "{\n    \"comment\": \"keep me\",\n    \"streams\": {},\n    \"server\": {\"http_port\": \":8083\"}\n}\n" {
+		t.Fatalf("delete only %v\n%s", err, data)
+	}
+	data, err = configPatch([]byte(testConfigLayout), "cam2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != strings.Replace(testConfigLayout, ",\n        \"cam2\": {\"url\": \"rtsp://10.0.0.2/b\"}", "", 1) {
+		t.Fatalf("delete last\n%s", data)
+	}
+}
+
+func TestConfigPatchCompact(t *testing.T) {
+	data, err := configPatch([]byte(`{"server":{},"streams":{}}`), "cam1", &StreamST{URL: "rtsp://10.0.0.1/a", OnDemand: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"server":{},"streams":{"cam1":{"url":"rtsp://10.0.0.1/a","status":false,"on_demand":true,"on_demand_idle":0,"audio":false,"fps_mode":"","fps_probe_time":0,"fps":0,"hls_segment_min_duration":0,"hls_segment_max_segments":0,"hls_part_target_ms":0}}}` {
+		t.Fatalf("compact\n%s", data)
+	}
+	//missing streams object added
+	if data, err = configPatch([]byte("{\n  \"server\": {}\n}\n"), "cam1", &StreamST{URL: "rtsp://10.0.0.1/a"}); err != nil || !strings.HasPrefix(string(data), "{\n  \"server\": {},\n  \"streams\": {\n    \"cam1\": {\n      \"url\": \"rtsp://10.0.0.1/a\",") {
+		t.Fatalf("no streams %v\n%s", err, data)
+	}
+	if _, err = configPatch([]byte(`[]`), "cam1", &StreamST{}); err != ErrorStreamConfigLayout {
+		t.Errorf("not object err %v", err)
+	}
+}
+
+func TestSaveConfigAtomic(t *testing.T) {
+	name := testConfigFile(t, testConfigLayout)
+	if err := os.Chmod(name, 0600); err != nil {
+		t.Fatal(err)
+	}
+	Config.mutex.Lock()
+	err := Config.saveConfig("cam2", nil)
+	Config.mutex.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//mode kept, temp file renamed away
+	info, err := os.Stat(name)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("mode %v %v", info.Mode(), err)
+	}
+	files, _ := ioutil.ReadDir(filepath.Dir(name))
+	if len(files) != 1 {
+		t.Errorf("files left %d", len(files))
+	}
+	tmp, err := readConfig()
+	if err != nil || len(tmp.Streams) != 1 {
+		t.Fatalf("read back %v", err)
+	}
+	//unparsable file not replaced, stream not applied
+	if err = ioutil.WriteFile(name, []byte("{broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = Config.StreamAdd("test-save", StreamST{URL: "rtsp://127.0.0.1:1/test", OnDemand: true}); err == nil {
+		t.Fatal("add over broken file no error")
+	}
+	if data, _ := ioutil.ReadFile(name); string(data) != "{broken" || Config.ext("test-save") {
+		t.Errorf("broken file %s", data)
+	}
+}
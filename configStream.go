@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"sync"
@@ -18,75 +21,461 @@ const (
 	FPSModePTS
 )
 
+const (
+	DefaultSegmentMinDuration = 4 //seconds
+	DefaultSegmentMaxSegments = 6
+	HoldBackSegments          = 4                //HOLD-BACK in target durations
+	PartHoldBackParts         = 4                //PART-HOLD-BACK in part targets
+	SkipUntilSegments         = 6                //CAN-SKIP-UNTIL in target durations
+	DefaultOnDemandIdle       = 30               //seconds without requests stop on demand stream
+	OnDemandStartTimeout      = 20 * time.Second //wait codecs and first part on demand start
+	DefaultFailbackCheck      = 30               //seconds between primary source probes on backup
+)
+
 //Config global
 var Config = loadConfig()
 
 //ConfigST struct
 type ConfigST struct {
-	mutex   sync.RWMutex
-	Server  ServerST            `json:"server"`
-	Streams map[string]StreamST `json:"streams"`
+	mutex    sync.RWMutex
+	shutdown bool                //No new workers on shutdown
+	saved    [sha256.Size]byte   //Hash of last saveConfig write, watch skip own change
+	Server   ServerST            `json:"server"`
+	Streams  map[string]StreamST `json:"streams"`
 }
 
 //ServerST struct
 type ServerST struct {
-	HTTPName  string `json:"http_server_name"`
-	HTTPPort  string `json:"http_port"`
-	HTTPSPort string `json:"https_port"`
+	HTTPName            string      `json:"http_server_name"`
+	HTTPPort            string      `json:"http_port"`
+	HTTPSPort           string      `json:"https_port"`
+	ConfigWatch         bool        `json:"config_watch,omitempty"`
+	Webhooks            []WebhookST `json:"webhooks,omitempty"`
+	RecordPath          string      `json:"record_path,omitempty"`
+	RecordRetentionDays int         `json:"record_retention_days,omitempty"`
+	RecordMaxDiskMB     int         `json:"record_max_disk_mb,omitempty"`
+	APIToken            string      `json:"api_token,omitempty"`
+	APIUser             string      `json:"api_user,omitempty"`
+	APIPassword         string      `json:"api_password,omitempty"`
+	ArchivePublic       bool        `json:"archive_public,omitempty"`
 }
 
 //StreamST struct
 type StreamST struct {
-	URL                   string    `json:"url"`
-	Status                bool      `json:"status"`
-	OnDemand              bool      `json:"on_demand"`
-	FPSMode               string    `json:"fps_mode"`
-	FPSProbeTime          int       `json:"fps_probe_time"`
-	FPS                   int       `json:"fps"`
-	HlsSegmentMinDuration int       `json:"hls_segment_min_duration"`
-	HlsSegmentMaxSegments int       `json:"hls_segment_max_segments"`
-	RunLock               bool      `json:"-"`
-	HlsMuxer              *MuxerHLS `json:"-"`
-	Codecs                []av.CodecData
+	URL                   string         `json:"url"`
+	URLs                  []string       `json:"urls,omitempty"`
+	Failback              bool           `json:"failback,omitempty"`
+	FailbackCheck         int            `json:"failback_check,omitempty"`
+	Status                bool           `json:"status"`
+	OnDemand              bool           `json:"on_demand"`
+	OnDemandIdle          int            `json:"on_demand_idle"`
+	Audio                 bool           `json:"audio"`
+	FPSMode               string         `json:"fps_mode"`
+	FPSProbeTime          int            `json:"fps_probe_time"`
+	FPS                   int            `json:"fps"`
+	HlsSegmentMinDuration int            `json:"hls_segment_min_duration"`
+	HlsSegmentMaxSegments int            `json:"hls_segment_max_segments"`
+	HlsPartTargetMS       int            `json:"hls_part_target_ms"`
+	ReconnectInitialMS    int            `json:"reconnect_initial_ms,omitempty"`
+	ReconnectMultiplier   float64        `json:"reconnect_multiplier,omitempty"`
+	ReconnectMaxMS        int            `json:"reconnect_max_ms,omitempty"`
+	ReconnectJitter       float64        `json:"reconnect_jitter,omitempty"`
+	ReconnectMaxAttempts  int            `json:"reconnect_max_attempts,omitempty"`
+	Webhooks              []WebhookST    `json:"webhooks,omitempty"`
+	Record                bool           `json:"record,omitempty"`
+	RecordPath            string         `json:"record_path,omitempty"`
+	RecordRetentionDays   int            `json:"record_retention_days,omitempty"`
+	RecordMaxSizeMB       int            `json:"record_max_size_mb,omitempty"`
+	LastRequest           time.Time      `json:"-"`
+	Supervisor            *Supervisor    `json:"-"`
+	HlsMuxer              *MuxerHLS      `json:"-"`
+	Codecs                []av.CodecData `json:"-"`
+	ready                 chan struct{}  //Closed on first part of muxer, nil create on wait
 }
 
+//ConfigFile config path load and save
+var ConfigFile = "config.json"
+
 //loadConfig func
 func loadConfig() *ConfigST {
-	var tmp ConfigST
-	data, err := ioutil.ReadFile("config.json")
+	tmp, err := readConfig()
 	if err != nil {
 		log.Fatalln(err)
 	}
+	for k, v := range tmp.Streams {
+		v.Supervisor = NewSupervisor(k)
+		tmp.Streams[k] = v
+	}
+	return tmp
+}
+
+//readConfig read and validate config file
+func readConfig() (*ConfigST, error) {
+	var tmp ConfigST
+	data, err := ioutil.ReadFile(ConfigFile)
+	if err != nil {
+		return nil, err
+	}
 	err = json.Unmarshal(data, &tmp)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
+	}
+	if tmp.Streams == nil {
+		tmp.Streams = make(map[string]StreamST)
 	}
-	return &tmp
+	if tmp.Server.RecordRetentionDays < 0 || tmp.Server.RecordMaxDiskMB < 0 {
+		return nil, fmt.Errorf("server %w", ErrorStreamRetentionNegative)
+	}
+	for _, hook := range tmp.Server.Webhooks {
+		err = hook.validate()
+		if err != nil {
+			return nil, fmt.Errorf("server %w", err)
+		}
+	}
+	for k, v := range tmp.Streams {
+		if !uuidValid(k) {
+			return nil, fmt.Errorf("%q %w", k, ErrorStreamUUIDInvalid)
+		}
+		err = v.validate()
+		if err != nil {
+			return nil, fmt.Errorf("%s %w", k, err)
+		}
+	}
+	return &tmp, nil
 }
 
-//RunIFNotRun if not run
+//validate check hls options, zero is default and stay zero in saved config
+func (element *StreamST) validate() error {
+	if len(element.sources()) == 0 || element.FailbackCheck < 0 {
+		return ErrorStreamSourceInvalid
+	}
+	if element.HlsSegmentMinDuration < 0 || element.HlsSegmentMaxSegments < 0 || element.HlsPartTargetMS < 0 || element.OnDemandIdle < 0 {
+		return ErrorStreamHlsOptionsNegative
+	}
+	if element.ReconnectInitialMS < 0 || element.ReconnectMaxMS < 0 || element.ReconnectMaxAttempts < 0 || element.ReconnectJitter < 0 || element.ReconnectJitter > 1 || (element.ReconnectMultiplier != 0 && element.ReconnectMultiplier < 1) {
+		return ErrorStreamReconnectInvalid
+	}
+	//part must fit segment
+	if element.partTarget() >= element.segmentMinDuration() {
+		return ErrorStreamPartTargetTooLong
+	}
+	//window must cover HOLD-BACK
+	if element.maxSegments() < HoldBackSegments {
+		return ErrorStreamWindowTooShort
+	}
+	for _, hook := range element.Webhooks {
+		if err := hook.validate(); err != nil {
+			return err
+		}
+	}
+	if element.RecordRetentionDays < 0 || element.RecordMaxSizeMB < 0 {
+		return ErrorStreamRetentionNegative
+	}
+	return nil
+}
+
+//sources url primary then urls failover order, empty and duplicate skipped
+func (element *StreamST) sources() []string {
+	var res []string
+	for _, url := range append([]string{element.URL}, element.URLs...) {
+		if url == "" || stringInSlice(url, res) {
+			continue
+		}
+		res = append(res, url)
+	}
+	return res
+}
+
+//failbackCheck primary probe interval 0 failback disabled
+func (element *StreamST) failbackCheck() time.Duration {
+	if !element.Failback {
+		return 0
+	}
+	if element.FailbackCheck == 0 {
+		return DefaultFailbackCheck * time.Second
+	}
+	return time.Duration(element.FailbackCheck) * time.Second
+}
+
+//recordPath stream record root, empty no record
+func (element *StreamST) recordPath(server string) string {
+	if !element.Record {
+		return ""
+	}
+	return element.recordRoot(server)
+}
+
+//recordRoot stream path then server path then default, old footage of stream with record off too
+func (element *StreamST) recordRoot(server string) string {
+	if element.RecordPath != "" {
+		return element.RecordPath
+	}
+	return serverRecordRoot(server)
+}
+
+//serverRecordRoot server path or default
+func serverRecordRoot(server string) string {
+	if server != "" {
+		return server
+	}
+	return DefaultRecordPath
+}
+
+//retention stream footage max age and size, age from server if not set, 0 keep
+func (element *StreamST) retention(server ServerST) RetentionST {
+	res := RetentionST{
+		Age:  time.Duration(element.RecordRetentionDays) * 24 * time.Hour,
+		Size: int64(element.RecordMaxSizeMB) << 20,
+	}
+	if res.Age == 0 {
+		res.Age = time.Duration(server.RecordRetentionDays) * 24 * time.Hour
+	}
+	return res
+}
+
+//segmentMinDuration config or default
+func (element *StreamST) segmentMinDuration() time.Duration {
+	if element.HlsSegmentMinDuration == 0 {
+		return DefaultSegmentMinDuration * time.Second
+	}
+	return time.Duration(element.HlsSegmentMinDuration) * time.Second
+}
+
+//maxSegments config or default
+func (element *StreamST) maxSegments() int {
+	if element.HlsSegmentMaxSegments == 0 {
+		return DefaultSegmentMaxSegments
+	}
+	return element.HlsSegmentMaxSegments
+}
+
+//partTarget config 0 use fps heuristic
+func (element *StreamST) partTarget() time.Duration {
+	return time.Duration(element.HlsPartTargetMS) * time.Millisecond
+}
+
+//onDemandIdle config or default
+func (element *StreamST) onDemandIdle() time.Duration {
+	if element.OnDemandIdle == 0 {
+		return DefaultOnDemandIdle * time.Second
+	}
+	return time.Duration(element.OnDemandIdle) * time.Second
+}
+
+//backoff reconnect policy config or default
+func (element *StreamST) backoff() BackoffST {
+	res := BackoffST{
+		Initial:     time.Duration(element.ReconnectInitialMS) * time.Millisecond,
+		Multiplier:  element.ReconnectMultiplier,
+		Max:         time.Duration(element.ReconnectMaxMS) * time.Millisecond,
+		Jitter:      element.ReconnectJitter,
+		MaxAttempts: element.ReconnectMaxAttempts,
+	}
+	if res.Initial == 0 {
+		res.Initial = DefaultReconnectInitial * time.Millisecond
+	}
+	if res.Multiplier == 0 {
+		res.Multiplier = DefaultReconnectMultiplier
+	}
+	if res.Max == 0 {
+		res.Max = DefaultReconnectMax * time.Millisecond
+	}
+	if res.Jitter == 0 {
+		res.Jitter = DefaultReconnectJitter
+	}
+	return res
+}
+
+//RunIFNotRun if not run, request keep on demand stream alive
 func (element *ConfigST) RunIFNotRun(uuid string) {
 	element.mutex.Lock()
 	defer element.mutex.Unlock()
 	if tmp, ok := element.Streams[uuid]; ok {
-		if tmp.OnDemand && !tmp.RunLock {
-			tmp.RunLock = true
-			element.Streams[uuid] = tmp
-			go RTSPWorkerLoop(uuid, tmp.URL)
+		tmp.LastRequest = time.Now()
+		element.Streams[uuid] = tmp
+		if tmp.OnDemand {
+			element.run(uuid)
 		}
 	}
 }
 
-//RunUnlock lock run stream
-func (element *ConfigST) RunUnlock(uuid string) {
+//StreamRun start always on stream worker if not run
+func (element *ConfigST) StreamRun(uuid string) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	if tmp, ok := element.Streams[uuid]; ok && !tmp.OnDemand {
+		element.run(uuid)
+	}
+}
+
+//run start worker one per stream, call under lock
+func (element *ConfigST) run(uuid string) {
+	if tmp, ok := element.Streams[uuid]; ok && !element.shutdown {
+		tmp.Supervisor.Start()
+	}
+}
+
+//release worker loop exit end playlist release muxer and codecs next start wait new
+func (element *ConfigST) release(uuid string, supervisor *Supervisor) {
 	element.mutex.Lock()
 	defer element.mutex.Unlock()
+	//stream deleted or replaced keep new one
+	if tmp, ok := element.Streams[uuid]; ok && tmp.Supervisor == supervisor {
+		if tmp.HlsMuxer != nil {
+			tmp.HlsMuxer.Close()
+		}
+		tmp.Codecs = nil
+		tmp.HlsMuxer = nil
+		tmp.ready = readyRelease(tmp.ready)
+		element.Streams[uuid] = tmp
+	}
+}
+
+//sources stream source urls and failback probe interval
+func (element *ConfigST) sources(uuid string) ([]string, time.Duration, bool) {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	tmp, ok := element.Streams[uuid]
+	return tmp.sources(), tmp.failbackCheck(), ok
+}
+
+//backoff stream reconnect policy
+func (element *ConfigST) backoff(uuid string) BackoffST {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	tmp := element.Streams[uuid]
+	return tmp.backoff()
+}
+
+//StreamState worker state
+func (element *ConfigST) StreamState(uuid string) (StreamStateST, error) {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
 	if tmp, ok := element.Streams[uuid]; ok {
-		if tmp.OnDemand && tmp.RunLock {
-			tmp.RunLock = false
-			element.Streams[uuid] = tmp
+		return tmp.Supervisor.Info(), nil
+	}
+	return StreamStateST{}, ErrorStreamNotFound
+}
+
+//StreamControl explicit worker operation start stop restart reconnect
+func (element *ConfigST) StreamControl(uuid string, operation string) error {
+	element.mutex.RLock()
+	tmp, ok := element.Streams[uuid]
+	shutdown := element.shutdown
+	element.mutex.RUnlock()
+	if !ok {
+		return ErrorStreamNotFound
+	}
+	if shutdown {
+		return ErrorStreamShutdown
+	}
+	switch operation {
+	case "start":
+		tmp.Supervisor.Start()
+	case "stop":
+		<-tmp.Supervisor.Stop()
+	case "restart":
+		tmp.Supervisor.Restart()
+	case "reconnect":
+		tmp.Supervisor.Reconnect()
+	case "reset":
+		tmp.Supervisor.Reset()
+	default:
+		return ErrorStreamOperationUnknown
+	}
+	return nil
+}
+
+//Shutdown end playlists stop workers wait RTSP TEARDOWN or deadline
+func (element *ConfigST) Shutdown(ctx context.Context) error {
+	element.mutex.Lock()
+	element.shutdown = true
+	var done []<-chan struct{}
+	for _, tmp := range element.Streams {
+		if tmp.HlsMuxer != nil {
+			tmp.HlsMuxer.Close()
+		}
+		done = append(done, tmp.Supervisor.Stop())
+	}
+	element.mutex.Unlock()
+	for _, ch := range done {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
+	return nil
+}
+
+//ack request on stream keep on demand stream alive
+func (element *ConfigST) ack(uuid string) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	if tmp, ok := element.Streams[uuid]; ok {
+		tmp.LastRequest = time.Now()
+		element.Streams[uuid] = tmp
+	}
+}
+
+//HasViewer always on or request inside on demand idle
+func (element *ConfigST) HasViewer(uuid string) bool {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	if tmp, ok := element.Streams[uuid]; ok {
+		return !tmp.OnDemand || time.Since(tmp.LastRequest) < tmp.onDemandIdle()
+	}
+	return false
+}
+
+//HLSMuxerWaitReady block until codecs and first part, on demand start
+func (element *ConfigST) HLSMuxerWaitReady(ctx context.Context, uuid string, timeout time.Duration) error {
+	element.mutex.Lock()
+	ready, ok := element.readyChan(uuid)
+	element.mutex.Unlock()
+	if !ok {
+		return ErrorStreamNotFound
+	}
+	//ready stream answer without timer
+	select {
+	case <-ready:
+		return nil
+	default:
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return ErrorStreamNotReady
+	}
+}
+
+//readyChan stream ready channel create if none, call under lock
+func (element *ConfigST) readyChan(uuid string) (chan struct{}, bool) {
+	tmp, ok := element.Streams[uuid]
+	if !ok {
+		return nil, false
+	}
+	if tmp.ready == nil {
+		tmp.ready = make(chan struct{})
+		element.Streams[uuid] = tmp
+	}
+	return tmp.ready, true
+}
+
+//readyRelease muxer released, closed channel dropped next start wait new
+func readyRelease(ready chan struct{}) chan struct{} {
+	select {
+	case <-ready:
+		return nil
+	default:
+		//waiters keep wait next session
+		return ready
+	}
 }
 
 //FPSMode func
@@ -112,6 +501,16 @@ func (element *ConfigST) FPSMode(uuid string) int {
 	return FPSModeProbe
 }
 
+//Audio func
+func (element *ConfigST) Audio(uuid string) bool {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	if tmp, ok := element.Streams[uuid]; ok {
+		return tmp.Audio
+	}
+	return false
+}
+
 //ext check stream exists
 func (element *ConfigST) ext(uuid string) bool {
 	element.mutex.Lock()
@@ -129,6 +528,20 @@ func (element *ConfigST) coAd(uuid string, codecs []av.CodecData) {
 	element.Streams[uuid] = t
 }
 
+//apiAuth api bearer token and basic auth user password
+func (element *ConfigST) apiAuth() (string, string, string) {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	return element.Server.APIToken, element.Server.APIUser, element.Server.APIPassword
+}
+
+//archivePublic archive playback without api auth
+func (element *ConfigST) archivePublic() bool {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	return element.Server.ArchivePublic
+}
+
 //HttpName func
 func (element *ConfigST) HttpName() string {
 	element.mutex.Lock()
@@ -183,13 +596,16 @@ func (element *ConfigST) list() (string, []string) {
 	return fist, res
 }
 
-//NewHLSMuxer new muxer init
+//NewHLSMuxer new muxer init, existing muxer survive source reconnect
 func (element *ConfigST) NewHLSMuxer(uuid string) {
 	element.mutex.Lock()
 	defer element.mutex.Unlock()
-	if tmp, ok := element.Streams[uuid]; ok {
-		tmp.HlsMuxer = NewHLSMuxer(uuid)
+	if tmp, ok := element.Streams[uuid]; ok && tmp.HlsMuxer == nil {
+		tmp.HlsMuxer = NewHLSMuxer(uuid, tmp.segmentMinDuration(), tmp.maxSegments(), tmp.partTarget())
+		tmp.HlsMuxer.SetRecord(tmp.recordPath(element.Server.RecordPath))
 		element.Streams[uuid] = tmp
+		ready, _ := element.readyChan(uuid)
+		tmp.HlsMuxer.SetReady(ready)
 	}
 }
 
@@ -197,59 +613,121 @@ func (element *ConfigST) NewHLSMuxer(uuid string) {
 func (element *ConfigST) HlsMuxerSetFPS(uuid string, fps int) {
 	element.mutex.Lock()
 	defer element.mutex.Unlock()
-	if tmp, ok := element.Streams[uuid]; ok {
+	if tmp, ok := element.Streams[uuid]; ok && tmp.HlsMuxer != nil {
 		tmp.HlsMuxer.SetFPS(fps)
 	}
 }
 
+//HlsMuxerSetCodecs set muxer codecs
+func (element *ConfigST) HlsMuxerSetCodecs(uuid string, codecs []av.CodecData) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	if tmp, ok := element.Streams[uuid]; ok && tmp.HlsMuxer != nil {
+		tmp.HlsMuxer.SetCodecs(codecs)
+	}
+}
+
 //HlsMuxerWritePacket write packet
-func (element *ConfigST) HlsMuxerWritePacket(uuid string, packet *av.Packet) {
+func (element *ConfigST) HlsMuxerWritePacket(uuid string, packet *av.Packet, wall time.Time) {
 	element.mutex.Lock()
 	defer element.mutex.Unlock()
-	if tmp, ok := element.Streams[uuid]; ok {
-		tmp.HlsMuxer.WritePacket(packet)
+	if tmp, ok := element.Streams[uuid]; ok && tmp.HlsMuxer != nil {
+		tmp.HlsMuxer.WritePacket(packet, wall)
+	}
+}
+
+//HLSMuxerDiscontinuity source lost next segment after discontinuity
+func (element *ConfigST) HLSMuxerDiscontinuity(uuid string) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	if tmp, ok := element.Streams[uuid]; ok && tmp.HlsMuxer != nil {
+		tmp.HlsMuxer.Discontinuity()
+	}
+}
+
+//HLSMuxerInit get init segment id -1 current
+func (element *ConfigST) HLSMuxerInit(uuid string, id int) ([]byte, error) {
+	element.mutex.Lock()
+	tmp, ok := element.Streams[uuid]
+	element.mutex.Unlock()
+	if ok && tmp.HlsMuxer != nil {
+		return tmp.HlsMuxer.GetInit(id)
 	}
+	return nil, ErrorStreamInitNotFound
 }
 
 //HLSMuxerClose close muxer
 func (element *ConfigST) HLSMuxerClose(uuid string) {
 	element.mutex.Lock()
 	defer element.mutex.Unlock()
-	if tmp, ok := element.Streams[uuid]; ok {
+	if tmp, ok := element.Streams[uuid]; ok && tmp.HlsMuxer != nil {
 		tmp.HlsMuxer.Close()
 	}
 }
 
-//HLSMuxerM3U8 get m3u8 list
-func (element *ConfigST) HLSMuxerM3U8(uuid string, msn, part int) (string, error) {
+//HLSMuxerM3U8 get m3u8 list skip delta update
+func (element *ConfigST) HLSMuxerM3U8(uuid string, msn, part int, skip bool) (string, error) {
 	element.mutex.Lock()
 	tmp, ok := element.Streams[uuid]
 	element.mutex.Unlock()
-	if ok {
-		index, err := tmp.HlsMuxer.GetIndexM3u8(msn, part)
+	if ok && tmp.HlsMuxer != nil {
+		index, err := tmp.HlsMuxer.GetIndexM3u8(msn, part, skip)
 		return index, err
 	}
 	return "", ErrorStreamNotFound
 }
 
+//HLSMuxerBandwidth get peak bandwidth
+func (element *ConfigST) HLSMuxerBandwidth(uuid string) int {
+	element.mutex.Lock()
+	tmp, ok := element.Streams[uuid]
+	element.mutex.Unlock()
+	if ok && tmp.HlsMuxer != nil {
+		return tmp.HlsMuxer.GetBandwidth()
+	}
+	return 0
+}
+
+//HLSMuxerCacheSize get serialized cache bytes
+func (element *ConfigST) HLSMuxerCacheSize(uuid string) int {
+	element.mutex.Lock()
+	tmp, ok := element.Streams[uuid]
+	element.mutex.Unlock()
+	if ok && tmp.HlsMuxer != nil {
+		return tmp.HlsMuxer.GetCacheSize()
+	}
+	return 0
+}
+
 //HLSMuxerSegment get segment
-func (element *ConfigST) HLSMuxerSegment(uuid string, segment int) ([]*av.Packet, error) {
+func (element *ConfigST) HLSMuxerSegment(uuid string, segment int) ([][]byte, error) {
 	element.mutex.Lock()
 	defer element.mutex.Unlock()
-	if tmp, ok := element.Streams[uuid]; ok {
+	if tmp, ok := element.Streams[uuid]; ok && tmp.HlsMuxer != nil {
 		return tmp.HlsMuxer.GetSegment(segment)
 	}
-	return nil, ErrorStreamSegmentNotFound
+	return nil, ErrorStreamNotFound
+}
+
+//HLSMuxerExport live window segments for export
+func (element *ConfigST) HLSMuxerExport(uuid string, start time.Time, end time.Time) []ExportSegmentST {
+	element.mutex.Lock()
+	tmp, ok := element.Streams[uuid]
+	element.mutex.Unlock()
+	if ok && tmp.HlsMuxer != nil {
+		return tmp.HlsMuxer.Export(start, end)
+	}
+	return nil
 }
 
 //HLSMuxerFragment get fragment
-func (element *ConfigST) HLSMuxerFragment(uuid string, segment, fragment int) ([]*av.Packet, error) {
+func (element *ConfigST) HLSMuxerFragment(uuid string, segment, fragment int) ([]byte, error) {
 	element.mutex.Lock()
 	tmp, ok := element.Streams[uuid]
 	element.mutex.Unlock()
-	if ok {
+	if ok && tmp.HlsMuxer != nil {
 		packet, err := tmp.HlsMuxer.GetFragment(segment, fragment)
 		return packet, err
 	}
-	return nil, ErrorStreamFragmentNotFound
+	return nil, ErrorStreamNotFound
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"strings"
+)
+
+//StreamsList copy of all streams
+func (element *ConfigST) StreamsList() map[string]StreamST {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	res := make(map[string]StreamST)
+	for k, v := range element.Streams {
+		res[k] = v.public()
+	}
+	return res
+}
+
+//StreamInfo copy of stream
+func (element *ConfigST) StreamInfo(uuid string) (*StreamST, error) {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	if tmp, ok := element.Streams[uuid]; ok {
+		tmp = tmp.public()
+		return &tmp, nil
+	}
+	return nil, ErrorStreamNotFound
+}
+
+//public copy for api, secrets and source credentials stay in config file
+func (element StreamST) public() StreamST {
+	element.Webhooks = webhooksPublic(element.Webhooks)
+	element.URL = urlRedact(element.URL)
+	if element.URLs != nil {
+		urls := make([]string, len(element.URLs))
+		for i, val := range element.URLs {
+			urls[i] = urlRedact(val)
+		}
+		element.URLs = urls
+	}
+	return element
+}
+
+//StreamAdd add stream and start worker if not on demand
+func (element *ConfigST) StreamAdd(uuid string, val StreamST) error {
+	if !uuidValid(uuid) {
+		return ErrorStreamUUIDInvalid
+	}
+	if err := val.validate(); err != nil {
+		return err
+	}
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	if _, ok := element.Streams[uuid]; ok {
+		return ErrorStreamAlreadyExists
+	}
+	//apply only after file written, failed write leave running config
+	if err := element.saveConfig(uuid, &val); err != nil {
+		return err
+	}
+	return element.streamAdd(uuid, val)
+}
+
+//streamAdd add validated stream, call under lock
+func (element *ConfigST) streamAdd(uuid string, val StreamST) error {
+	if _, ok := element.Streams[uuid]; ok {
+		return ErrorStreamAlreadyExists
+	}
+	val.Supervisor = NewSupervisor(uuid)
+	val.HlsMuxer = nil
+	val.Codecs = nil
+	val.ready = nil
+	element.Streams[uuid] = val
+	if !val.OnDemand {
+		element.run(uuid)
+	}
+	return nil
+}
+
+//StreamEdit update stream keep muxer, source change reconnect with discontinuity
+func (element *ConfigST) StreamEdit(uuid string, val StreamST) error {
+	if err := val.validate(); err != nil {
+		return err
+	}
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	tmp, ok := element.Streams[uuid]
+	if !ok {
+		return ErrorStreamNotFound
+	}
+	val = tmp.restore(val)
+	if err := element.saveConfig(uuid, &val); err != nil {
+		return err
+	}
+	return element.streamEdit(uuid, val, false)
+}
+
+//restore masked values client send back from get
+func (element StreamST) restore(val StreamST) StreamST {
+	val.Webhooks = webhooksRestore(val.Webhooks, element.Webhooks)
+	old := element.sources()
+	val.URL = urlRestore(val.URL, old)
+	for i := range val.URLs {
+		val.URLs[i] = urlRestore(val.URLs[i], old)
+	}
+	return val
+}
+
+//streamEdit update validated stream restart force reconnect, call under lock
+func (element *ConfigST) streamEdit(uuid string, val StreamST, restart bool) error {
+	tmp, ok := element.Streams[uuid]
+	if !ok {
+		return ErrorStreamNotFound
+	}
+	//runtime state stay with stream
+	val.LastRequest = tmp.LastRequest
+	val.Supervisor = tmp.Supervisor
+	val.HlsMuxer = tmp.HlsMuxer
+	val.Codecs = tmp.Codecs
+	val.ready = tmp.ready
+	if val.HlsMuxer != nil {
+		val.HlsMuxer.SetOptions(val.segmentMinDuration(), val.maxSegments(), val.partTarget())
+		val.HlsMuxer.SetRecord(val.recordPath(element.Server.RecordPath))
+	}
+	element.Streams[uuid] = val
+	if restart || tmp.sourceChanged(val) {
+		tmp.Supervisor.Reconnect()
+	}
+	if !val.OnDemand {
+		element.run(uuid)
+	}
+	return nil
+}
+
+//sourceChanged source, audio or fps detection changed need new source session
+func (element *StreamST) sourceChanged(val StreamST) bool {
+	return strings.Join(val.sources(), " ") != strings.Join(element.sources(), " ") || val.Audio != element.Audio || val.FPSMode != element.FPSMode || val.FPS != element.FPS || val.FPSProbeTime != element.FPSProbeTime
+}
+
+//hlsChanged segment, window or part options changed
+func (element *StreamST) hlsChanged(val StreamST) bool {
+	return val.HlsSegmentMinDuration != element.HlsSegmentMinDuration || val.HlsSegmentMaxSegments != element.HlsSegmentMaxSegments || val.HlsPartTargetMS != element.HlsPartTargetMS
+}
+
+//StreamDelete stop worker and release muxer
+func (element *ConfigST) StreamDelete(uuid string) error {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	if _, ok := element.Streams[uuid]; !ok {
+		return ErrorStreamNotFound
+	}
+	if err := element.saveConfig(uuid, nil); err != nil {
+		return err
+	}
+	return element.streamDelete(uuid)
+}
+
+//streamDelete stop worker and release muxer, call under lock
+func (element *ConfigST) streamDelete(uuid string) error {
+	tmp, ok := element.Streams[uuid]
+	if !ok {
+		return ErrorStreamNotFound
+	}
+	tmp.Supervisor.Stop()
+	if tmp.HlsMuxer != nil {
+		tmp.HlsMuxer.Close()
+	}
+	delete(element.Streams, uuid)
+	return nil
+}
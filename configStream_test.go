@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+//testStream add stream to global config without save, stop and remove on cleanup
+func testStream(t *testing.T, uuid string, val StreamST) *Supervisor {
+	t.Helper()
+	val.Supervisor = NewSupervisor(uuid)
+	Config.mutex.Lock()
+	Config.Streams[uuid] = val
+	Config.mutex.Unlock()
+	t.Cleanup(func() {
+		<-val.Supervisor.Stop()
+		Config.mutex.Lock()
+		delete(Config.Streams, uuid)
+		Config.mutex.Unlock()
+	})
+	return val.Supervisor
+}
+
+//testWaitState wait supervisor state or fail
+func testWaitState(t *testing.T, supervisor *Supervisor, state string, timeout time.Duration) {
+	t.Helper()
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if supervisor.Info().State == state {
+			return
+		}
+	}
+	t.Fatalf("state %s want %s", supervisor.Info().State, state)
+}
+
+func TestStreamValidate(t *testing.T) {
+	url := "rtsp://127.0.0.1/test"
+	tests := []struct {
+		stream StreamST
+		err    error
+	}{
+		{stream: StreamST{URL: url}},
+		{stream: StreamST{URL: url, HlsSegmentMinDuration: 1, HlsSegmentMaxSegments: 4, HlsPartTargetMS: 999}},
+		{stream: StreamST{URL: url, HlsSegmentMinDuration: -1}, err: ErrorStreamHlsOptionsNegative},
+		{stream: StreamST{URL: url, HlsPartTargetMS: -200}, err: ErrorStreamHlsOptionsNegative},
+		{stream: StreamST{URL: url, HlsSegmentMinDuration: 1, HlsPartTargetMS: 1000}, err: ErrorStreamPartTargetTooLong},
+		//default 4s segment
+		{stream: StreamST{URL: url, HlsPartTargetMS: 4000}, err: ErrorStreamPartTargetTooLong},
+		{stream: StreamST{URL: url, HlsSegmentMaxSegments: HoldBackSegments - 1}, err: ErrorStreamWindowTooShort},
+	}
+	for i, test := range tests {
+		if err := test.stream.validate(); err != test.err {
+			t.Errorf("%d %+v err %v want %v", i, test.stream, err, test.err)
+		}
+	}
+	//zero option stay zero, default from helpers
+	stream := StreamST{URL: url}
+	if stream.segmentMinDuration() != DefaultSegmentMinDuration*time.Second || stream.maxSegments() != DefaultSegmentMaxSegments || stream.partTarget() != 0 {
+		t.Errorf("defaults %v %d %v", stream.segmentMinDuration(), stream.maxSegments(), stream.partTarget())
+	}
+}
+
+func TestOnDemandIdle(t *testing.T) {
+	//refused source fail fast, worker retry while viewer
+	supervisor := testStream(t, "test-on-demand", StreamST{URL: "rtsp://127.0.0.1:1/test", OnDemand: true, OnDemandIdle: 1, ReconnectInitialMS: 100, ReconnectMaxMS: 100})
+	if Config.HasViewer("test-on-demand") {
+		t.Fatal("viewer before request")
+	}
+	Config.StreamRun("test-on-demand")
+	if supervisor.Running() {
+		t.Fatal("on demand started without request")
+	}
+	Config.RunIFNotRun("test-on-demand")
+	if !supervisor.Running() || !Config.HasViewer("test-on-demand") {
+		t.Fatal("request not start stream")
+	}
+	testWaitState(t, supervisor, StreamStateReconnecting, time.Second)
+	//no request in idle period stop worker
+	testWaitState(t, supervisor, StreamStateStopped, 3*time.Second)
+	if supervisor.Running() || Config.HasViewer("test-on-demand") {
+		t.Error("idle stream still running")
+	}
+	//always on stream always has viewer
+	testStream(t, "test-always-on", StreamST{URL: "rtsp://127.0.0.1:1/test"})
+	if !Config.HasViewer("test-always-on") {
+		t.Error("always on without viewer")
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	supervisor := testStream(t, "test-ready", StreamST{URL: "rtsp://127.0.0.1:1/test", OnDemand: true})
+	if err := Config.HLSMuxerWaitReady(context.Background(), "test-missing", time.Second); err != ErrorStreamNotFound {
+		t.Errorf("missing err %v", err)
+	}
+	if err := Config.HLSMuxerWaitReady(context.Background(), "test-ready", 50*time.Millisecond); err != ErrorStreamNotReady {
+		t.Errorf("timeout err %v", err)
+	}
+	//viewer gone before ready
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Config.HLSMuxerWaitReady(ctx, "test-ready", time.Second); err != context.Canceled {
+		t.Errorf("canceled err %v", err)
+	}
+	//waiter before muxer exist wake on first part
+	res := make(chan error)
+	go func() {
+		res <- Config.HLSMuxerWaitReady(context.Background(), "test-ready", 5*time.Second)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	muxer := testMuxer(t, 4)
+	Config.NewHLSMuxer("test-ready")
+	Config.HlsMuxerSetFPS("test-ready", 25)
+	Config.HlsMuxerSetCodecs("test-ready", muxer.Codecs)
+	Config.coAd("test-ready", muxer.Codecs)
+	for i := 0; i < 10; i++ {
+		Config.HlsMuxerWritePacket("test-ready", &av.Packet{IsKeyFrame: i == 0, Time: time.Duration(i) * 40 * time.Millisecond, Duration: 40 * time.Millisecond, Data: []byte{0, 0, 0, 1, 1}}, time.Now())
+	}
+	select {
+	case err := <-res:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter not woken by first part")
+	}
+	if err := Config.HLSMuxerWaitReady(context.Background(), "test-ready", 0); err != nil {
+		t.Errorf("ready stream err %v", err)
+	}
+	//released muxer next start wait again
+	Config.release("test-ready", supervisor)
+	if err := Config.HLSMuxerWaitReady(context.Background(), "test-ready", 50*time.Millisecond); err != ErrorStreamNotReady {
+		t.Errorf("after release err %v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	supervisor := testStream(t, "test-shutdown", StreamST{URL: "rtsp://127.0.0.1:1/test"})
+	supervisor.Start()
+	testWaitState(t, supervisor, StreamStateReconnecting, 5*time.Second)
+	muxer := testMuxer(t, 6)
+	testWriteVideo(muxer, 30, 10, time.Now())
+	//own config, global keep running for other tests
+	element := &ConfigST{Streams: map[string]StreamST{"test-shutdown": {Supervisor: supervisor, HlsMuxer: muxer}}}
+	blocked := make(chan string, 1)
+	go func() {
+		index, _ := muxer.GetIndexM3u8(muxer.MSN+1, 0, false)
+		blocked <- index
+	}()
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := element.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if supervisor.Running() {
+		t.Error("worker running after shutdown")
+	}
+	//blocked playlist request woken with ENDLIST, open segment finished
+	select {
+	case index := <-blocked:
+		if !strings.HasSuffix(index, "#EXT-X-ENDLIST\n") {
+			t.Errorf("blocked request\n%s", index)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked request not woken")
+	}
+	if index := muxer.index(false); !strings.HasSuffix(index, "#EXT-X-ENDLIST\n") || strings.Count(index, "#EXTINF:") != 2 {
+		t.Errorf("index after shutdown\n%s", index)
+	}
+	//no new workers
+	element.mutex.Lock()
+	element.run("test-shutdown")
+	element.mutex.Unlock()
+	if supervisor.Running() || element.StreamControl("test-shutdown", "start") != ErrorStreamShutdown {
+		t.Error("worker start after shutdown")
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+
+	"github.com/deepch/vdk/format/mp4/mp4io"
+)
+
+const (
+	ExportMaxRange  = time.Hour //longest clip, keep 32-bit sample offsets and durations
+	exportTimeScale = 1000      //mvhd timescale
+)
+
+//ExportSegmentST segment from live window or archive for mp4 export
+type ExportSegmentST struct {
+	Time     time.Time     //Segment wall clock start
+	Duration time.Duration //Segment duration
+	Init     []byte        //Init segment for segment codecs
+	Path     string        //Archive segment file, empty live
+	Data     [][]byte      //Live fragments
+}
+
+//ExportST progressive clip, moov before mdat, mdat read again from segments on write
+type ExportST struct {
+	Start    time.Time //Wall clock of first key frame
+	End      time.Time //Wall clock of clip end
+	Size     int64     //File size
+	header   []byte    //ftyp+moov+mdat header
+	segments []ExportSegmentST
+	samples  []exportSampleST
+}
+
+//exportSampleST selected sample location in mdat order
+type exportSampleST struct {
+	Segment int
+	Buffer  int
+	Offset  int
+	Size    int
+}
+
+//exportTrackST init trak with selected sample timing
+type exportTrackST struct {
+	atom     *mp4io.Track
+	scale    int64
+	video    bool
+	dts      []int64
+	duration []uint32
+	cts      []uint32
+	key      []bool
+	size     []uint32
+	offset   []uint32
+}
+
+//exportCandidateST parsed sample with wall clock
+type exportCandidateST struct {
+	FragmentSampleST
+	wall    time.Time
+	gap     time.Duration
+	segment int
+	buffer  int
+}
+
+//Export clip from archive and live window, live window cover newest and win on overlap
+func (element *ConfigST) Export(uuid string, start time.Time, end time.Time) (*ExportST, error) {
+	if !end.After(start) || end.Sub(start) > ExportMaxRange {
+		return nil, ErrorStreamExportRange
+	}
+	files, err := element.Archive(uuid, start, end)
+	if err != nil && err != ErrorStreamArchiveNotFound {
+		return nil, err
+	}
+	live := element.HLSMuxerExport(uuid, start, end)
+	var segments []ExportSegmentST
+	inits := make(map[string][]byte)
+	for _, file := range files {
+		//recorded copy of live segment start same ms truncated
+		if len(live) > 0 && !file.Time.Add(file.Duration/2).Before(live[0].Time) {
+			break
+		}
+		init, ok := inits[file.Init]
+		if !ok {
+			if init, err = ioutil.ReadFile(file.Init); err != nil {
+				return nil, err
+			}
+			inits[file.Init] = init
+		}
+		segments = append(segments, ExportSegmentST{Time: file.Time, Duration: file.Duration, Init: init, Path: file.Path})
+	}
+	segments = append(segments, live...)
+	if len(segments) == 0 {
+		return nil, ErrorStreamArchiveNotFound
+	}
+	return NewExport(segments, start, end)
+}
+
+//NewExport cut from last master key frame at or before start to first master key frame at or after end,
+//recording gaps collapsed, one init for whole clip
+func NewExport(segments []ExportSegmentST, start time.Time, end time.Time) (*ExportST, error) {
+	moov, tracks, master, err := exportMovie(segments[0].Init)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []exportCandidateST
+	var gap time.Duration
+	var prevEnd time.Time
+	for i := range segments {
+		if !bytes.Equal(segments[i].Init, segments[0].Init) {
+			return nil, ErrorStreamExportCodecChange
+		}
+		if i > 0 && segments[i].Time.Sub(prevEnd) > ArchiveGap {
+			gap += segments[i].Time.Sub(prevEnd)
+		}
+		prevEnd = segments[i].Time.Add(segments[i].Duration)
+		data, err := segments[i].load()
+		if err != nil {
+			return nil, err
+		}
+		var samples []exportCandidateST
+		base := int64(-1)
+		for j, buf := range data {
+			tmp, err := ParseFragment(buf)
+			if err != nil {
+				return nil, err
+			}
+			for _, sample := range tmp {
+				if _, ok := tracks[sample.TrackID]; !ok {
+					return nil, ErrorStreamExportInvalid
+				}
+				if sample.TrackID == master && base < 0 {
+					base = sample.DTS
+				}
+				samples = append(samples, exportCandidateST{FragmentSampleST: sample, gap: gap, segment: i, buffer: j})
+			}
+		}
+		//segment wall clock is master first decode time, tracks share decode timeline
+		if base < 0 {
+			continue
+		}
+		offset := tsToTime(base, tracks[master].scale)
+		for _, sample := range samples {
+			sample.wall = segments[i].Time.Add(tsToTime(sample.DTS, tracks[sample.TrackID].scale) - offset)
+			candidates = append(candidates, sample)
+		}
+	}
+	res := &ExportST{segments: segments}
+	for _, sample := range candidates {
+		if sample.TrackID != master || !sample.Key {
+			continue
+		}
+		if res.Start.IsZero() || !sample.wall.After(start) {
+			res.Start = sample.wall
+		}
+		if !sample.wall.Before(end) && sample.wall.After(res.Start) {
+			res.End = sample.wall
+			break
+		}
+	}
+	if res.Start.IsZero() {
+		return nil, ErrorStreamArchiveNotFound
+	}
+	var last time.Time
+	for _, sample := range candidates {
+		if sample.wall.Before(res.Start) || (!res.End.IsZero() && !sample.wall.Before(res.End)) {
+			continue
+		}
+		track := tracks[sample.TrackID]
+		dts := timeToTs(sample.wall.Sub(res.Start)-sample.gap, track.scale)
+		//wall clock jitter across reconnect never move decode time back
+		if n := len(track.dts); n > 0 && dts < track.dts[n-1] {
+			dts = track.dts[n-1]
+		}
+		track.dts = append(track.dts, dts)
+		track.duration = append(track.duration, sample.Duration)
+		track.cts = append(track.cts, uint32(sample.CTS))
+		track.key = append(track.key, sample.Key)
+		track.size = append(track.size, uint32(sample.Size))
+		track.offset = append(track.offset, uint32(res.Size))
+		res.samples = append(res.samples, exportSampleST{Segment: sample.segment, Buffer: sample.buffer, Offset: sample.Offset, Size: sample.Size})
+		res.Size += int64(sample.Size)
+		if sample.TrackID == master {
+			last = sample.wall.Add(tsToTime(int64(sample.Duration), track.scale))
+		}
+	}
+	//no key frame after end clip run to last master sample end
+	if res.End.IsZero() {
+		res.End = last
+	}
+	res.header, err = exportHeader(moov, tracks, res.Size)
+	if err != nil {
+		return nil, err
+	}
+	res.Size += int64(len(res.header))
+	return res, nil
+}
+
+//Write header then sample data in mdat order, archive segment read again
+func (element *ExportST) Write(w io.Writer) error {
+	writer := bufio.NewWriterSize(w, 1<<16)
+	if _, err := writer.Write(element.header); err != nil {
+		return err
+	}
+	last := -1
+	var data [][]byte
+	for _, sample := range element.samples {
+		if sample.Segment != last {
+			var err error
+			if data, err = element.segments[sample.Segment].load(); err != nil {
+				return err
+			}
+			last = sample.Segment
+		}
+		//archive file replaced or removed after cut
+		if sample.Buffer >= len(data) || sample.Offset+sample.Size > len(data[sample.Buffer]) {
+			return ErrorStreamExportInvalid
+		}
+		if _, err := writer.Write(data[sample.Buffer][sample.Offset : sample.Offset+sample.Size]); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+//load segment buffers, archive read from disk
+func (element *ExportSegmentST) load() ([][]byte, error) {
+	if element.Path == "" {
+		return element.Data, nil
+	}
+	data, err := ioutil.ReadFile(element.Path)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{data}, nil
+}
+
+//exportMovie moov of init with tracks by id, master first video track
+func exportMovie(init []byte) (*mp4io.Movie, map[uint32]*exportTrackST, uint32, error) {
+	for n := 0; n < len(init); {
+		name, size, ok := getBox(init[n:])
+		if !ok {
+			break
+		}
+		if name != "moov" {
+			n += size
+			continue
+		}
+		moov := &mp4io.Movie{}
+		if _, err := moov.Unmarshal(init[n:n+size], 0); err != nil {
+			return nil, nil, 0, err
+		}
+		tracks := make(map[uint32]*exportTrackST)
+		var master uint32
+		for _, atom := range moov.Tracks {
+			if atom.Header == nil || atom.Media == nil || atom.Media.Header == nil || atom.Media.Header.TimeScale <= 0 || atom.Media.Info == nil || atom.Media.Info.Sample == nil {
+				return nil, nil, 0, ErrorStreamExportInvalid
+			}
+			id := uint32(atom.Header.TrackId)
+			track := &exportTrackST{atom: atom, scale: int64(atom.Media.Header.TimeScale)}
+			track.video = atom.Media.Handler != nil && string(atom.Media.Handler.SubType[:]) == "vide"
+			if master == 0 || (track.video && !tracks[master].video) {
+				master = id
+			}
+			tracks[id] = track
+		}
+		if master == 0 {
+			return nil, nil, 0, ErrorStreamExportInvalid
+		}
+		return moov, tracks, master, nil
+	}
+	return nil, nil, 0, ErrorStreamExportInvalid
+}
+
+//exportHeader ftyp, moov with sample tables one sample per chunk and mdat header
+func exportHeader(moov *mp4io.Movie, tracks map[uint32]*exportTrackST, size int64) ([]byte, error) {
+	ftyp := make([]byte, 32)
+	n := putBox(ftyp, "ftyp", len(ftyp))
+	n += copy(ftyp[n:], "isom")
+	binary.BigEndian.PutUint32(ftyp[n:], 0x200)
+	copy(ftyp[n+4:], "isomiso2avc1mp41")
+	moov.MovieExtend = nil
+	var atoms []*mp4io.Track
+	var duration int64
+	for _, atom := range moov.Tracks {
+		track := tracks[uint32(atom.Header.TrackId)]
+		if len(track.dts) == 0 {
+			continue
+		}
+		sample := &mp4io.SampleTable{
+			SampleDesc:    atom.Media.Info.Sample.SampleDesc,
+			TimeToSample:  &mp4io.TimeToSample{},
+			SampleToChunk: &mp4io.SampleToChunk{Entries: []mp4io.SampleToChunkEntry{{FirstChunk: 1, SamplesPerChunk: 1, SampleDescId: 1}}},
+			SampleSize:    &mp4io.SampleSize{Entries: track.size},
+			ChunkOffset:   &mp4io.ChunkOffset{Entries: make([]uint32, len(track.offset))},
+		}
+		var total int64
+		var cts, stss bool
+		for i := range track.dts {
+			//duration from next decode time, last sample keep fragment duration
+			dur := track.duration[i]
+			if i+1 < len(track.dts) {
+				dur = uint32(track.dts[i+1] - track.dts[i])
+			}
+			total += int64(dur)
+			if entries := sample.TimeToSample.Entries; len(entries) > 0 && entries[len(entries)-1].Duration == dur {
+				sample.TimeToSample.Entries[len(entries)-1].Count++
+			} else {
+				sample.TimeToSample.Entries = append(entries, mp4io.TimeToSampleEntry{Count: 1, Duration: dur})
+			}
+			cts = cts || track.cts[i] != 0
+			stss = stss || !track.key[i]
+		}
+		if cts {
+			sample.CompositionOffset = &mp4io.CompositionOffset{}
+			for _, offset := range track.cts {
+				if entries := sample.CompositionOffset.Entries; len(entries) > 0 && entries[len(entries)-1].Offset == offset {
+					sample.CompositionOffset.Entries[len(entries)-1].Count++
+				} else {
+					sample.CompositionOffset.Entries = append(entries, mp4io.CompositionOffsetEntry{Count: 1, Offset: offset})
+				}
+			}
+		}
+		//no stss all samples sync
+		if stss {
+			sample.SyncSample = &mp4io.SyncSample{}
+			for i, key := range track.key {
+				if key {
+					sample.SyncSample.Entries = append(sample.SyncSample.Entries, uint32(i+1))
+				}
+			}
+		}
+		if total > math.MaxInt32 {
+			return nil, ErrorStreamExportRange
+		}
+		atom.Media.Info.Sample = sample
+		atom.Media.Header.Duration = int32(total)
+		atom.Header.Duration = int32(timeToTs(tsToTime(total, track.scale), exportTimeScale))
+		if int64(atom.Header.Duration) > duration {
+			duration = int64(atom.Header.Duration)
+		}
+		atoms = append(atoms, atom)
+	}
+	moov.Tracks = atoms
+	moov.Header.TimeScale = exportTimeScale
+	moov.Header.Duration = int32(duration)
+	//offsets fixed size, moov length known before fill
+	base := int64(len(ftyp) + moov.Len() + 8)
+	if base+size > math.MaxUint32 {
+		return nil, ErrorStreamExportRange
+	}
+	for _, atom := range moov.Tracks {
+		track := tracks[uint32(atom.Header.TrackId)]
+		for i, offset := range track.offset {
+			atom.Media.Info.Sample.ChunkOffset.Entries[i] = uint32(base) + offset
+		}
+	}
+	res := make([]byte, base)
+	copy(res, ftyp)
+	moov.Marshal(res[len(ftyp):])
+	putBox(res[base-8:], "mdat", int(size+8))
+	return res, nil
+}
+
+//tsToTime convert timescale to duration without overflow
+func tsToTime(val int64, scale int64) time.Duration {
+	return time.Duration(val/scale)*time.Second + time.Duration(val%scale)*time.Second/time.Duration(scale)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/format/mp4/mp4io"
+)
+
+//testExportCodecs h264 and optional aac 44.1kHz
+func testExportCodecs(t *testing.T, audio bool) []av.CodecData {
+	t.Helper()
+	video, err := h264parser.NewCodecDataFromSPSAndPPS(testSPS, testPPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := []av.CodecData{video}
+	if audio {
+		aac, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes([]byte{0x12, 0x10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, aac)
+	}
+	return res
+}
+
+//testExportSegment live segment 25 fps key every second when keys, aac frames when audio codec
+func testExportSegment(t *testing.T, codecs []av.CodecData, wall time.Time, dts time.Duration, duration time.Duration, keys bool) ExportSegmentST {
+	t.Helper()
+	init, err := MarshalInit(codecs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packets []*av.Packet
+	for i := 0; time.Duration(i)*40*time.Millisecond < duration; i++ {
+		packets = append(packets, &av.Packet{Idx: 0, IsKeyFrame: keys && i%25 == 0, Time: dts + time.Duration(i)*40*time.Millisecond, Duration: 40 * time.Millisecond, Data: bytes.Repeat([]byte{byte(i)}, 10+i%7)})
+	}
+	if len(codecs) > 1 {
+		step := 1024 * time.Second / 44100
+		for at := time.Duration(0); at < duration; at += step {
+			packets = append(packets, &av.Packet{Idx: 1, Time: dts + at, Duration: step, Data: []byte{0xaa, 0xbb}})
+		}
+	}
+	data, err := MarshalFragment(codecs, 1, packets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ExportSegmentST{Time: wall, Duration: duration, Init: init, Data: [][]byte{data}}
+}
+
+//testExportTables sample tables of clip header by track id
+func testExportTables(t *testing.T, clip *ExportST) map[int32]*mp4io.SampleTable {
+	t.Helper()
+	for n := 0; n < len(clip.header); {
+		name, size, ok := getBox(clip.header[n:])
+		if !ok {
+			break
+		}
+		if name == "moov" {
+			moov := &mp4io.Movie{}
+			if _, err := moov.Unmarshal(clip.header[n:n+size], 0); err != nil {
+				t.Fatal(err)
+			}
+			res := make(map[int32]*mp4io.SampleTable)
+			for _, track := range moov.Tracks {
+				res[track.Header.TrackId] = track.Media.Info.Sample
+			}
+			return res
+		}
+		n += size
+	}
+	t.Fatal("moov not found")
+	return nil
+}
+
+func TestNewExport(t *testing.T) {
+	base := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	codecs := testExportCodecs(t, false)
+	segments := []ExportSegmentST{
+		testExportSegment(t, codecs, base, 0, 2*time.Second, true),
+		testExportSegment(t, codecs, base.Add(2*time.Second), 2*time.Second, 2*time.Second, true),
+		testExportSegment(t, codecs, base.Add(4*time.Second), 4*time.Second, 2*time.Second, true),
+	}
+	//start key at or before start, end before first key at or after end
+	clip, err := NewExport(segments, base.Add(1500*time.Millisecond), base.Add(3500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !clip.Start.Equal(base.Add(time.Second)) || !clip.End.Equal(base.Add(4*time.Second)) {
+		t.Errorf("clip %v-%v", clip.Start.Sub(base), clip.End.Sub(base))
+	}
+	video := testExportTables(t, clip)[1]
+	if len(video.SampleSize.Entries) != 75 || video.SyncSample == nil || len(video.SyncSample.Entries) != 3 || video.SyncSample.Entries[1] != 26 {
+		t.Errorf("samples %d stss %+v", len(video.SampleSize.Entries), video.SyncSample)
+	}
+	if entries := video.TimeToSample.Entries; len(entries) != 1 || entries[0].Count != 75 || entries[0].Duration != 3600 {
+		t.Errorf("stts %+v", entries)
+	}
+	var buf bytes.Buffer
+	if err = clip.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if int64(buf.Len()) != clip.Size {
+		t.Errorf("written %d size %d", buf.Len(), clip.Size)
+	}
+	//start on key frame, end after footage run to last sample
+	clip, err = NewExport(segments[:1], base.Add(time.Second), base.Add(time.Minute))
+	if err != nil || !clip.Start.Equal(base.Add(time.Second)) || !clip.End.Equal(base.Add(2*time.Second)) {
+		t.Errorf("key start %+v %v", clip, err)
+	}
+}
+
+func TestNewExportGap(t *testing.T) {
+	base := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	codecs := testExportCodecs(t, false)
+	segments := []ExportSegmentST{
+		testExportSegment(t, codecs, base, 0, 2*time.Second, true),
+		testExportSegment(t, codecs, base.Add(12*time.Second), 2*time.Second, 2*time.Second, true),
+	}
+	clip, err := NewExport(segments, base, base.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//gap collapsed, constant frame rate one stts entry
+	video := testExportTables(t, clip)[1]
+	if entries := video.TimeToSample.Entries; len(entries) != 1 || entries[0].Count != 100 || entries[0].Duration != 3600 {
+		t.Errorf("stts %+v", entries)
+	}
+	if !clip.End.Equal(base.Add(14 * time.Second)) {
+		t.Errorf("end %v", clip.End.Sub(base))
+	}
+}
+
+func TestNewExportAudio(t *testing.T) {
+	base := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	segments := []ExportSegmentST{testExportSegment(t, testExportCodecs(t, true), base, 0, 3*time.Second, true)}
+	clip, err := NewExport(segments, base.Add(1500*time.Millisecond), base.Add(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//audio cut to video second, all sync no stss
+	tables := testExportTables(t, clip)
+	if len(tables[1].SampleSize.Entries) != 25 {
+		t.Errorf("video samples %d", len(tables[1].SampleSize.Entries))
+	}
+	if audio := tables[2]; audio == nil || len(audio.SampleSize.Entries) < 43 || len(audio.SampleSize.Entries) > 44 || audio.SyncSample != nil {
+		t.Errorf("audio %+v", audio)
+	}
+}
+
+func TestNewExportInvalid(t *testing.T) {
+	base := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	video := testExportCodecs(t, false)
+	change := []ExportSegmentST{
+		testExportSegment(t, video, base, 0, 2*time.Second, true),
+		testExportSegment(t, testExportCodecs(t, true), base.Add(2*time.Second), 2*time.Second, 2*time.Second, true),
+	}
+	if _, err := NewExport(change, base, base.Add(3*time.Second)); err != ErrorStreamExportCodecChange {
+		t.Errorf("codec change %v", err)
+	}
+	if _, err := NewExport([]ExportSegmentST{testExportSegment(t, video, base, 0, 2*time.Second, false)}, base, base.Add(time.Second)); err != ErrorStreamArchiveNotFound {
+		t.Errorf("no key frame %v", err)
+	}
+	if _, err := NewExport([]ExportSegmentST{{Time: base, Duration: time.Second, Init: []byte{0, 0, 0, 8, 'f', 'r', 'e', 'e'}}}, base, base.Add(time.Second)); err != ErrorStreamExportInvalid {
+		t.Errorf("invalid init %v", err)
+	}
+}
@@ -4,9 +4,9 @@ go 1.16
 
 require (
 	github.com/deepch/vdk v0.0.0-20210508200759-5adbbcc01f89
-	github.com/gin-contrib/cors v1.3.1 // indirect
+	github.com/gin-contrib/cors v1.3.1
 	github.com/gin-contrib/gzip v0.0.3 // indirect
-	github.com/gin-gonic/autotls v0.0.3
 	github.com/gin-gonic/gin v1.7.1
+	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
 	golang.org/x/net v0.0.0-20210119194325-5f4716e94777 // indirect
 )
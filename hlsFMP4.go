@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/format/mp4f"
+)
+
+const (
+	fmp4SampleKey    = 0x02000000 //sample_depends_on=2 no other
+	fmp4SampleNonKey = 0x01010000 //sample_depends_on=1 is_non_sync
+	fmp4TfhdFlags    = 0x020000   //default-base-is-moof
+	fmp4TrunFlags    = 0x000f01   //data-offset duration size flags cts
+)
+
+//TimeScale mp4 track timescale for codec
+func TimeScale(codec av.CodecData) int64 {
+	if codec.Type().IsAudio() {
+		if audio, ok := codec.(av.AudioCodecData); ok && audio.SampleRate() > 0 {
+			return int64(audio.SampleRate())
+		}
+	}
+	return 90000
+}
+
+//timeToTs convert duration to timescale without overflow on long run streams
+func timeToTs(val time.Duration, scale int64) int64 {
+	return int64(val/time.Second)*scale + int64(val%time.Second)*scale/int64(time.Second)
+}
+
+//MarshalInit build init segment ftyp+moov for codecs
+func MarshalInit(codecs []av.CodecData) ([]byte, error) {
+	muxer := mp4f.NewMuxer(nil)
+	if err := muxer.WriteHeader(codecs); err != nil {
+		return nil, err
+	}
+	_, buf := muxer.GetInit(codecs)
+	return buf, nil
+}
+
+//MarshalFragment build moof+mdat one traf per track
+func MarshalFragment(codecs []av.CodecData, seq uint32, packets []*av.Packet) ([]byte, error) {
+	tracks := make([][]*av.Packet, len(codecs))
+	for _, packet := range packets {
+		if packet.Idx < 0 || int(packet.Idx) >= len(codecs) {
+			return nil, ErrorStreamTrackNotFound
+		}
+		tracks[packet.Idx] = append(tracks[packet.Idx], packet)
+	}
+	moofSize := 8 + 16
+	mdatSize := 8
+	for _, track := range tracks {
+		if len(track) == 0 {
+			continue
+		}
+		moofSize += 8 + 16 + 20 + 20 + 16*len(track)
+		for _, packet := range track {
+			mdatSize += len(packet.Data)
+		}
+	}
+	buf := make([]byte, moofSize+mdatSize)
+	n := putBox(buf, "moof", moofSize)
+	n += putBox(buf[n:], "mfhd", 16)
+	binary.BigEndian.PutUint32(buf[n+4:], seq)
+	n += 8
+	dataOffset := moofSize + 8
+	for trackID, track := range tracks {
+		if len(track) == 0 {
+			continue
+		}
+		scale := TimeScale(codecs[trackID])
+		n += putBox(buf[n:], "traf", 8+16+20+20+16*len(track))
+		n += putBox(buf[n:], "tfhd", 16)
+		binary.BigEndian.PutUint32(buf[n:], fmp4TfhdFlags)
+		binary.BigEndian.PutUint32(buf[n+4:], uint32(trackID+1))
+		n += 8
+		n += putBox(buf[n:], "tfdt", 20)
+		binary.BigEndian.PutUint32(buf[n:], 1<<24)
+		binary.BigEndian.PutUint64(buf[n+4:], uint64(timeToTs(track[0].Time, scale)))
+		n += 12
+		n += putBox(buf[n:], "trun", 20+16*len(track))
+		binary.BigEndian.PutUint32(buf[n:], fmp4TrunFlags)
+		binary.BigEndian.PutUint32(buf[n+4:], uint32(len(track)))
+		binary.BigEndian.PutUint32(buf[n+8:], uint32(dataOffset))
+		n += 12
+		for i, packet := range track {
+			//duration from next decode time keep track without rounding drift
+			end := packet.Time + packet.Duration
+			if i+1 < len(track) {
+				end = track[i+1].Time
+			}
+			flags := uint32(fmp4SampleNonKey)
+			if packet.IsKeyFrame || codecs[trackID].Type().IsAudio() {
+				flags = fmp4SampleKey
+			}
+			binary.BigEndian.PutUint32(buf[n:], uint32(timeToTs(end, scale)-timeToTs(packet.Time, scale)))
+			binary.BigEndian.PutUint32(buf[n+4:], uint32(len(packet.Data)))
+			binary.BigEndian.PutUint32(buf[n+8:], flags)
+			binary.BigEndian.PutUint32(buf[n+12:], uint32(timeToTs(packet.CompositionTime, scale)))
+			n += 16
+			dataOffset += len(packet.Data)
+		}
+	}
+	n += putBox(buf[n:], "mdat", mdatSize)
+	for _, track := range tracks {
+		for _, packet := range track {
+			n += copy(buf[n:], packet.Data)
+		}
+	}
+	return buf, nil
+}
+
+//FragmentSampleST sample parsed back from moof+mdat
+type FragmentSampleST struct {
+	TrackID  uint32 //tfhd track id
+	DTS      int64  //Decode time track timescale
+	Duration uint32 //Duration track timescale
+	CTS      int32  //Composition offset track timescale
+	Key      bool   //Sync sample
+	Offset   int    //Data offset in buffer
+	Size     int    //Data size
+}
+
+//ParseFragment samples of moof+mdat pairs in buffer, inverse of MarshalFragment
+func ParseFragment(buf []byte) ([]FragmentSampleST, error) {
+	var res []FragmentSampleST
+	for n := 0; n < len(buf); {
+		name, size, ok := getBox(buf[n:])
+		if !ok {
+			return nil, ErrorStreamExportInvalid
+		}
+		if name == "moof" {
+			samples, err := parseMoof(buf, n, n+size)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, samples...)
+		}
+		n += size
+	}
+	return res, nil
+}
+
+//parseMoof samples of traf boxes, offsets from moof start or tfhd base
+func parseMoof(buf []byte, start int, end int) ([]FragmentSampleST, error) {
+	var res []FragmentSampleST
+	for n := start + 8; n < end; {
+		name, size, ok := getBox(buf[n:end])
+		if !ok {
+			return nil, ErrorStreamExportInvalid
+		}
+		if name == "traf" {
+			samples, err := parseTraf(buf, start, buf[n+8:n+size])
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, samples...)
+		}
+		n += size
+	}
+	return res, nil
+}
+
+//parseTraf tfhd defaults, tfdt base decode time and trun samples
+func parseTraf(buf []byte, moof int, traf []byte) ([]FragmentSampleST, error) {
+	var res []FragmentSampleST
+	var trackID, defaultDuration, defaultSize, defaultFlags uint32
+	var dts int64
+	base := int64(moof)
+	for n := 0; n < len(traf); {
+		name, size, ok := getBox(traf[n:])
+		if !ok || size < 12 {
+			return nil, ErrorStreamExportInvalid
+		}
+		box := traf[n+8 : n+size]
+		flags := binary.BigEndian.Uint32(box) & 0xffffff
+		switch name {
+		case "tfhd":
+			fields := box[4:]
+			if len(fields) < 4 {
+				return nil, ErrorStreamExportInvalid
+			}
+			trackID = binary.BigEndian.Uint32(fields)
+			fields = fields[4:]
+			for _, field := range []struct {
+				flag uint32
+				size int
+				val  *uint32
+			}{{0x01, 8, nil}, {0x02, 4, nil}, {0x08, 4, &defaultDuration}, {0x10, 4, &defaultSize}, {0x20, 4, &defaultFlags}} {
+				if flags&field.flag == 0 {
+					continue
+				}
+				if len(fields) < field.size {
+					return nil, ErrorStreamExportInvalid
+				}
+				if field.flag == 0x01 {
+					base = int64(binary.BigEndian.Uint64(fields))
+				} else if field.val != nil {
+					*field.val = binary.BigEndian.Uint32(fields)
+				}
+				fields = fields[field.size:]
+			}
+		case "tfdt":
+			if box[0] == 1 && len(box) >= 12 {
+				dts = int64(binary.BigEndian.Uint64(box[4:]))
+			} else if len(box) >= 8 {
+				dts = int64(binary.BigEndian.Uint32(box[4:]))
+			}
+		case "trun":
+			if len(box) < 8 {
+				return nil, ErrorStreamExportInvalid
+			}
+			count := int(binary.BigEndian.Uint32(box[4:]))
+			fields := box[8:]
+			offset := base
+			if flags&0x01 != 0 {
+				if len(fields) < 4 {
+					return nil, ErrorStreamExportInvalid
+				}
+				offset += int64(int32(binary.BigEndian.Uint32(fields)))
+				fields = fields[4:]
+			}
+			firstFlags, first := defaultFlags, false
+			if flags&0x04 != 0 {
+				if len(fields) < 4 {
+					return nil, ErrorStreamExportInvalid
+				}
+				firstFlags, first = binary.BigEndian.Uint32(fields), true
+				fields = fields[4:]
+			}
+			for i := 0; i < count; i++ {
+				sample := FragmentSampleST{TrackID: trackID, DTS: dts, Duration: defaultDuration, Size: int(defaultSize), Offset: int(offset)}
+				sampleFlags := defaultFlags
+				if i == 0 && first {
+					sampleFlags = firstFlags
+				}
+				for _, field := range []uint32{0x100, 0x200, 0x400, 0x800} {
+					if flags&field == 0 {
+						continue
+					}
+					if len(fields) < 4 {
+						return nil, ErrorStreamExportInvalid
+					}
+					val := binary.BigEndian.Uint32(fields)
+					fields = fields[4:]
+					switch field {
+					case 0x100:
+						sample.Duration = val
+					case 0x200:
+						sample.Size = int(val)
+					case 0x400:
+						sampleFlags = val
+					case 0x800:
+						//version 0 unsigned version 1 signed, both fit int32 for sane offsets
+						sample.CTS = int32(val)
+					}
+				}
+				//sample_is_non_sync_sample
+				sample.Key = sampleFlags&0x00010000 == 0
+				if sample.Offset < 0 || sample.Size < 0 || sample.Offset+sample.Size > len(buf) {
+					return nil, ErrorStreamExportInvalid
+				}
+				res = append(res, sample)
+				dts += int64(sample.Duration)
+				offset += int64(sample.Size)
+			}
+		}
+		n += size
+	}
+	return res, nil
+}
+
+//getBox box type and size, size cover header and fit buffer
+func getBox(buf []byte) (string, int, bool) {
+	if len(buf) < 8 {
+		return "", 0, false
+	}
+	size := int(binary.BigEndian.Uint32(buf))
+	if size < 8 || size > len(buf) {
+		return "", 0, false
+	}
+	return string(buf[4:8]), size, true
+}
+
+//putBox write box size and type return header size
+func putBox(buf []byte, name string, size int) int {
+	binary.BigEndian.PutUint32(buf, uint32(size))
+	copy(buf[4:8], name)
+	return 8
+}
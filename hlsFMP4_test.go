@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+func TestFragmentRoundTrip(t *testing.T) {
+	video, err := h264parser.NewCodecDataFromSPSAndPPS(testSPS, testPPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes([]byte{0x12, 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := 1024 * time.Second / 44100
+	//30 days decode time need 64-bit tfdt, second frame 33ms duration from next time
+	start := 30 * 24 * time.Hour
+	packets := []*av.Packet{
+		{Idx: 0, IsKeyFrame: true, Time: start, Duration: 40 * time.Millisecond, CompositionTime: 80 * time.Millisecond, Data: []byte{0, 0, 0, 2, 0x65, 1}},
+		{Idx: 1, Time: start, Duration: frame, Data: []byte{9, 9, 9}},
+		{Idx: 0, Time: start + 33*time.Millisecond, Duration: 40 * time.Millisecond, Data: []byte{0, 0, 0, 1, 0x41}},
+		{Idx: 1, Time: start + frame, Duration: frame, Data: []byte{8, 8}},
+	}
+	buf, err := MarshalFragment([]av.CodecData{video, audio}, 7, packets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq := binary.BigEndian.Uint32(buf[20:]); seq != 7 {
+		t.Errorf("mfhd sequence %d want 7", seq)
+	}
+	samples, err := ParseFragment(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FragmentSampleST{
+		{TrackID: 1, DTS: 30 * 24 * 3600 * 90000, Duration: 2970, CTS: 7200, Key: true, Size: 6},
+		{TrackID: 1, DTS: 30*24*3600*90000 + 2970, Duration: 3600, Size: 5},
+		{TrackID: 2, DTS: 30 * 24 * 3600 * 44100, Duration: 1023, Key: true, Size: 3},
+		{TrackID: 2, DTS: 30*24*3600*44100 + 1023, Duration: 1024, Key: true, Size: 2},
+	}
+	if len(samples) != len(want) {
+		t.Fatalf("samples %d want %d", len(samples), len(want))
+	}
+	//mdat track by track
+	data := [][]byte{packets[0].Data, packets[2].Data, packets[1].Data, packets[3].Data}
+	for i, sample := range samples {
+		if !bytes.Equal(buf[sample.Offset:sample.Offset+sample.Size], data[i]) {
+			t.Errorf("sample %d data mismatch", i)
+		}
+		sample.Offset = 0
+		if sample != want[i] {
+			t.Errorf("sample %d %+v want %+v", i, sample, want[i])
+		}
+	}
+}
+
+func TestFragmentInvalid(t *testing.T) {
+	video, err := h264parser.NewCodecDataFromSPSAndPPS(testSPS, testPPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MarshalFragment([]av.CodecData{video}, 1, []*av.Packet{{Idx: 1, Data: []byte{1}}}); err != ErrorStreamTrackNotFound {
+		t.Errorf("unknown track err %v", err)
+	}
+	buf, err := MarshalFragment([]av.CodecData{video}, 1, []*av.Packet{{Idx: 0, IsKeyFrame: true, Duration: time.Second, Data: []byte{0, 0, 0, 1, 0x65}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{4, 20, len(buf) - 1} {
+		if _, err := ParseFragment(buf[:size]); err == nil {
+			t.Errorf("truncated %d no error", size)
+		}
+	}
+}
+
+func TestMuxerFragmentContinuous(t *testing.T) {
+	muxer := testMuxer(t, 10)
+	testWriteVideo(muxer, 100, 100, time.Now())
+	muxer.Close()
+	var seq uint32
+	var next int64
+	for _, id := range muxer.SortSegments(muxer.Segments) {
+		segment := muxer.Segments[id]
+		for _, part := range muxer.SortFragment(segment.Fragment) {
+			buf := segment.Fragment[part].Data
+			//sequence and tfdt continue over parts and segments
+			if val := binary.BigEndian.Uint32(buf[20:]); val != seq+1 {
+				t.Fatalf("segment %d part %d sequence %d want %d", id, part, val, seq+1)
+			}
+			seq++
+			samples, err := ParseFragment(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, sample := range samples {
+				if sample.DTS != next {
+					t.Fatalf("segment %d part %d dts %d want %d", id, part, sample.DTS, next)
+				}
+				next += int64(sample.Duration)
+			}
+		}
+	}
+	if next != 100*3600 {
+		t.Errorf("end dts %d want %d", next, 100*3600)
+	}
+}
@@ -8,15 +8,18 @@ import (
 
 //Fragment struct
 type Fragment struct {
-	Independent bool          //Fragment have i-frame (key frame)
-	Finish      bool          //Fragment Ready
-	Duration    time.Duration //Fragment Duration
-	Packets     []*av.Packet  //Packet Slice
+	Independent    bool          //Fragment have i-frame (key frame)
+	Finish         bool          //Fragment Ready
+	SequenceNumber uint32        //moof sequence number on stream
+	Duration       time.Duration //Fragment Duration
+	Packets        []*av.Packet  //Packet Slice release after serialize
+	Data           []byte        //Serialized moof+mdat immutable after close
+	callback       func(*Fragment)
 }
 
 //NewFragment open new fragment
 func (element *Segment) NewFragment() *Fragment {
-	res := &Fragment{}
+	res := &Fragment{callback: element.callback}
 	element.Fragment[element.CurrentFragmentID] = res
 	return res
 }
@@ -27,12 +30,14 @@ func (element *Fragment) GetDuration() time.Duration {
 }
 
 //WritePacket to fragment func
-func (element *Fragment) WritePacket(packet *av.Packet) {
-	//increase fragment dur
-	element.Duration += packet.Duration
-	//Independent if have key
-	if packet.IsKeyFrame {
-		element.Independent = true
+func (element *Fragment) WritePacket(packet *av.Packet, master bool) {
+	if master {
+		//increase fragment dur
+		element.Duration += packet.Duration
+		//Independent if have key
+		if packet.IsKeyFrame {
+			element.Independent = true
+		}
 	}
 	//append packet to slice of packet
 	element.Packets = append(element.Packets, packet)
@@ -40,7 +45,9 @@ func (element *Fragment) WritePacket(packet *av.Packet) {
 
 //Close fragment block func
 func (element *Fragment) Close() {
-	//TODO add callback func
 	//finalize fragment
 	element.Finish = true
+	if element.callback != nil {
+		element.callback(element)
+	}
 }
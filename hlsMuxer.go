@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"log"
 	"math"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,68 +16,360 @@ import (
 
 //MuxerHLS struct
 type MuxerHLS struct {
-	mutex             sync.RWMutex
-	UUID              string             //Current UUID
-	MSN               int                //Current MSN
-	FPS               int                //Current FPS
-	MediaSequence     int                //Current MediaSequence
-	CurrentFragmentID int                //Current fragment id
-	CacheM3U8         string             //Current index cache
-	CurrentSegment    *Segment           //Current segment link
-	Segments          map[int]*Segment   //Current segments group
-	FragmentCtx       context.Context    //chan 1-N
-	FragmentCancel    context.CancelFunc //chan 1-N
+	mutex              sync.RWMutex
+	UUID               string                 //Current UUID
+	MSN                int                    //Current MSN
+	FPS                int                    //Current FPS
+	SegmentMinDuration time.Duration          //Min segment duration split on next key
+	SegmentTarget      int                    //Highest EXT-X-TARGETDURATION sent, never lower between reloads
+	MaxSegments        int                    //Max segments in playlist window
+	PartTarget         time.Duration          //Part target 0 use fps heuristic
+	TimeIdx            int8                   //Track index drive segment and part duration
+	Codecs             []av.CodecData         //Current codecs serialize fragments
+	CacheSize          int                    //Serialized fragments bytes in window
+	SequenceNumber     uint32                 //Last moof sequence number
+	TrackTime          map[int8]time.Duration //Track next decode time
+	TrackBase          time.Duration          //Decode time new tracks start after reconnect
+	DiscontinuityNext  bool                   //Next segment start after discontinuity
+	DiscontinuitySeq   int                    //EXT-X-DISCONTINUITY-SEQUENCE
+	InitID             int                    //Current init segment id
+	Inits              map[int][]byte         //Init segments referenced by window
+	Closed             bool                   //Stream end EXT-X-ENDLIST
+	RecordPath         string                 //Record closed segments root empty no record
+	KeyFrameTime       time.Time              //Wall clock of last video key frame
+	GOPFrames          int                    //Frames between last two video key frames
+	GOPDuration        time.Duration          //Duration between last two video key frames
+	GOPCount           int                    //Frames since last video key frame
+	GOPStart           time.Duration          //Decode time of last video key frame
+	MediaSequence      int                    //Current MediaSequence
+	CurrentFragmentID  int                    //Current fragment id
+	CacheM3U8          string                 //Current index cache
+	CacheM3U8Skip      string                 //Current delta update index cache
+	CurrentSegment     *Segment               //Current segment link
+	Segments           map[int]*Segment       //Current segments group
+	FragmentCtx        context.Context        //chan 1-N
+	FragmentCancel     context.CancelFunc     //chan 1-N
+	ready              chan struct{}          //Closed on first serialized part, nil after
 }
 
 //NewHLSMuxer Segments
-func NewHLSMuxer(uuid string) *MuxerHLS {
+func NewHLSMuxer(uuid string, segmentMinDuration time.Duration, maxSegments int, partTarget time.Duration) *MuxerHLS {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &MuxerHLS{
-		UUID:           uuid,
-		MSN:            -1,
-		Segments:       make(map[int]*Segment),
-		FragmentCtx:    ctx,
-		FragmentCancel: cancel,
+		UUID:               uuid,
+		MSN:                -1,
+		SegmentMinDuration: segmentMinDuration,
+		MaxSegments:        maxSegments,
+		PartTarget:         partTarget,
+		Segments:           make(map[int]*Segment),
+		TrackTime:          make(map[int8]time.Duration),
+		Inits:              make(map[int][]byte),
+		FragmentCtx:        ctx,
+		FragmentCancel:     cancel,
 	}
 }
 
+//SetOptions update segment and part options apply from next segment
+func (element *MuxerHLS) SetOptions(segmentMinDuration time.Duration, maxSegments int, partTarget time.Duration) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	element.SegmentMinDuration = segmentMinDuration
+	element.MaxSegments = maxSegments
+	element.PartTarget = partTarget
+}
+
+//SetRecord record root empty stop record from next segment
+func (element *MuxerHLS) SetRecord(path string) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	element.RecordPath = path
+}
+
 //SetFPS func
 func (element *MuxerHLS) SetFPS(fps int) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
 	element.FPS = fps
 }
 
-//WritePacket func
-func (element *MuxerHLS) WritePacket(packet *av.Packet) {
+//SetCodecs select track drive timeline video first, new init on codec change
+func (element *MuxerHLS) SetCodecs(codecs []av.CodecData) {
 	element.mutex.Lock()
 	defer element.mutex.Unlock()
-	//TODO delete packet.IsKeyFrame if need no EXT-X-INDEPENDENT-SEGMENTS
-	if packet.IsKeyFrame && (element.CurrentSegment == nil || element.CurrentSegment.GetDuration().Seconds() >= 4) {
-		if element.CurrentSegment != nil {
-			element.CurrentSegment.Close()
-			if len(element.Segments) > 6 {
-				delete(element.Segments, element.MSN-6)
-				element.MediaSequence++
-			}
+	init, err := MarshalInit(codecs)
+	if err != nil {
+		log.Println(element.UUID, "SetCodecs MarshalInit Error", err)
+	} else if old, ok := element.Inits[element.InitID]; !ok || !bytes.Equal(old, init) {
+		if ok {
+			//old segments keep old EXT-X-MAP new start after discontinuity
+			element.InitID++
+			element.discontinuity()
 		}
+		element.Inits[element.InitID] = init
+		if element.MSN >= 0 {
+			element.UpdateIndexM3u8()
+		}
+	}
+	element.Codecs = codecs
+	element.TimeIdx = 0
+	for i, codec := range codecs {
+		if codec.Type().IsVideo() {
+			element.TimeIdx = int8(i)
+			break
+		}
+	}
+}
+
+//WritePacket func wall is packet wall clock for PROGRAM-DATE-TIME
+func (element *MuxerHLS) WritePacket(packet *av.Packet, wall time.Time) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	if element.Closed {
+		return
+	}
+	master := packet.Idx == element.TimeIdx
+	//decode time continue on stream time line tfdt never reset
+	if next, ok := element.TrackTime[packet.Idx]; ok {
+		packet.Time = next
+	} else {
+		packet.Time += element.TrackBase
+	}
+	element.TrackTime[packet.Idx] = packet.Time + packet.Duration
+	if master && element.Codecs[packet.Idx].Type().IsVideo() {
+		element.gop(packet, wall)
+	}
+	//TODO delete packet.IsKeyFrame if need no EXT-X-INDEPENDENT-SEGMENTS
+	if master && packet.IsKeyFrame && (element.CurrentSegment == nil || element.CurrentSegment.GetDuration() >= element.SegmentMinDuration) {
+		element.closeSegment()
 		element.CurrentSegment = element.NewSegment()
+		element.CurrentSegment.Time = wall
 		element.CurrentSegment.SetFPS(element.FPS)
+		element.CurrentSegment.SetPartTarget(element.GetPartTarget())
+	}
+	//wait first key before audio
+	if element.CurrentSegment == nil {
+		return
 	}
-	element.CurrentSegment.WritePacket(packet)
-	CurrentFragmentID := element.CurrentSegment.GetFragmentID()
-	if CurrentFragmentID != element.CurrentFragmentID {
+	element.CurrentSegment.WritePacket(packet, master)
+	element.CurrentFragmentID = element.CurrentSegment.GetFragmentID()
+	//new fragment previous closed
+	if element.CurrentSegment.CurrentFragment.SequenceNumber == 0 {
+		element.SequenceNumber++
+		element.CurrentSegment.CurrentFragment.SequenceNumber = element.SequenceNumber
 		element.UpdateIndexM3u8()
 	}
-	element.CurrentFragmentID = CurrentFragmentID
+}
+
+//record queue closed segment for disk, call under lock
+func (element *MuxerHLS) record(segment *Segment) {
+	var data [][]byte
+	for _, id := range element.SortFragment(segment.Fragment) {
+		if segment.Fragment[id].Data == nil {
+			log.Println(element.UUID, "Record Skip Segment Without Data")
+			return
+		}
+		data = append(data, segment.Fragment[id].Data)
+	}
+	if len(data) == 0 || segment.Duration <= 0 {
+		return
+	}
+	//codecs still of closed segment, SetCodecs close segment before update
+	res := RecordSegmentST{
+		Path:     element.RecordPath,
+		UUID:     element.UUID,
+		Time:     segment.Time,
+		Duration: segment.Duration,
+		Init:     element.Inits[segment.InitID],
+		Data:     data,
+		Codecs:   codecString(element.Codecs),
+	}
+	if codec := element.Codecs[element.TimeIdx]; codec.Type().IsVideo() {
+		res.Width, res.Height = videoSize(codec)
+	}
+	Recorder.Write(res)
+}
+
+//Export finished segments in window overlap start end with init
+func (element *MuxerHLS) Export(start time.Time, end time.Time) []ExportSegmentST {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	var res []ExportSegmentST
+	for _, id := range element.SortSegments(element.Segments) {
+		segment := element.Segments[id]
+		if !segment.Finish || segment.Duration <= 0 || !segment.Time.Add(segment.Duration).After(start) || !segment.Time.Before(end) {
+			continue
+		}
+		var data [][]byte
+		for _, fragment := range element.SortFragment(segment.Fragment) {
+			if segment.Fragment[fragment].Data == nil {
+				data = nil
+				break
+			}
+			data = append(data, segment.Fragment[fragment].Data)
+		}
+		if len(data) == 0 {
+			continue
+		}
+		res = append(res, ExportSegmentST{Time: segment.Time, Duration: segment.Duration, Init: element.Inits[segment.InitID], Data: data})
+	}
+	return res
+}
+
+//gop track key frame interval on master video, call under lock
+func (element *MuxerHLS) gop(packet *av.Packet, wall time.Time) {
+	if packet.IsKeyFrame {
+		if !element.KeyFrameTime.IsZero() {
+			element.GOPFrames = element.GOPCount
+			element.GOPDuration = packet.Time - element.GOPStart
+		}
+		element.KeyFrameTime = wall
+		element.GOPStart = packet.Time
+		element.GOPCount = 0
+	}
+	element.GOPCount++
+}
+
+//closeSegment finish current segment and evict old from window, call under lock
+func (element *MuxerHLS) closeSegment() {
+	if element.CurrentSegment == nil {
+		return
+	}
+	element.CurrentSegment.Close()
+	Metrics.Segment(element.UUID, element.CurrentSegment.Duration)
+	if element.RecordPath != "" {
+		element.record(element.CurrentSegment)
+	}
+	element.CurrentSegment = nil
+	//loop window may shrink on options update
+	for len(element.Segments) > element.MaxSegments {
+		if segment, ok := element.Segments[element.MediaSequence]; ok {
+			element.CacheSize -= segment.GetSize()
+			if segment.Discontinuity {
+				element.DiscontinuitySeq++
+			}
+		}
+		delete(element.Segments, element.MediaSequence)
+		element.MediaSequence++
+	}
+	//drop init segments no longer referenced
+	for id := range element.Inits {
+		if id == element.InitID {
+			continue
+		}
+		var used bool
+		for _, segment := range element.Segments {
+			if segment.InitID == id {
+				used = true
+				break
+			}
+		}
+		if !used {
+			delete(element.Inits, id)
+		}
+	}
+}
+
+//Discontinuity source reconnect keep window and MSN next segment discontinuity
+func (element *MuxerHLS) Discontinuity() {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	if element.MSN < 0 {
+		return
+	}
+	element.discontinuity()
+	//new source time line start after longest track
+	for _, next := range element.TrackTime {
+		if next > element.TrackBase {
+			element.TrackBase = next
+		}
+	}
+	element.TrackTime = make(map[int8]time.Duration)
+	element.UpdateIndexM3u8()
+}
+
+//discontinuity finish segment next segment discontinuity, call under lock
+func (element *MuxerHLS) discontinuity() {
+	if element.MSN < 0 {
+		return
+	}
+	element.closeSegment()
+	element.DiscontinuityNext = true
+}
+
+//SetReady channel close on first serialized part, on demand start waiters
+func (element *MuxerHLS) SetReady(ready chan struct{}) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	element.ready = ready
+}
+
+//GetInit serialized init segment id -1 current
+func (element *MuxerHLS) GetInit(id int) ([]byte, error) {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	if id == -1 {
+		id = element.InitID
+	}
+	if init, ok := element.Inits[id]; ok {
+		return init, nil
+	}
+	return nil, ErrorStreamInitNotFound
+}
+
+//CloseFragment serialize fragment once on close, call under lock
+func (element *MuxerHLS) CloseFragment(fragment *Fragment) {
+	buf, err := MarshalFragment(element.Codecs, fragment.SequenceNumber, fragment.Packets)
+	if err != nil {
+		log.Println(element.UUID, "CloseFragment MarshalFragment Error", err)
+		return
+	}
+	fragment.Data = buf
+	fragment.Packets = nil
+	if element.ready != nil {
+		close(element.ready)
+		element.ready = nil
+	}
+	Metrics.Part(element.UUID, fragment.Duration)
+	element.CacheSize += len(buf)
+}
+
+//GetCacheSize serialized bytes in window
+func (element *MuxerHLS) GetCacheSize() int {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	return element.CacheSize
+}
+
+//GetPartTarget return config part target or fps heuristic
+func (element *MuxerHLS) GetPartTarget() time.Duration {
+	if element.PartTarget != 0 {
+		return element.PartTarget
+	}
+	return time.Duration(FragmentMS(element.FPS)) * time.Millisecond
 }
 
 //UpdateIndexM3u8 func
 func (element *MuxerHLS) UpdateIndexM3u8() {
 	var header string
-	var body string
-	var partTarget time.Duration
-	var segmentTarget time.Duration
-	segmentTarget = time.Second * 2
-	for _, segmentKey := range element.SortSegments(element.Segments) {
+	partTarget := element.GetPartTarget()
+	segmentTarget := int(math.Ceil(element.SegmentMinDuration.Seconds()))
+	if element.SegmentTarget > segmentTarget {
+		segmentTarget = element.SegmentTarget
+	}
+	segmentKeys := element.SortSegments(element.Segments)
+	bodies := make([]string, len(segmentKeys))
+	initID := -1
+	for i, segmentKey := range segmentKeys {
+		var body string
+		if element.Segments[segmentKey].Discontinuity {
+			body += "#EXT-X-DISCONTINUITY\n"
+		}
+		if element.Segments[segmentKey].InitID != initID {
+			initID = element.Segments[segmentKey].InitID
+			body += "#EXT-X-MAP:URI=\"init/" + strconv.Itoa(initID) + "/init.mp4\"\n"
+		}
+		//date before first part apply to whole segment
+		body += "#EXT-X-PROGRAM-DATE-TIME:" + element.Segments[segmentKey].Time.UTC().Format("2006-01-02T15:04:05.000Z") + "\n"
 		for _, fragmentKey := range element.SortFragment(element.Segments[segmentKey].Fragment) {
 			if element.Segments[segmentKey].Fragment[fragmentKey].Finish {
 				var independent string
@@ -83,44 +377,155 @@ func (element *MuxerHLS) UpdateIndexM3u8() {
 					independent = ",INDEPENDENT=YES"
 				}
 				body += "#EXT-X-PART:DURATION=" + strconv.FormatFloat(element.Segments[segmentKey].Fragment[fragmentKey].GetDuration().Seconds(), 'f', 5, 64) + "" + independent + ",URI=\"fragment/" + strconv.Itoa(segmentKey) + "/" + strconv.Itoa(fragmentKey) + "/0qrm9ru6." + strconv.Itoa(fragmentKey) + ".m4s\"\n"
-				partTarget = element.Segments[segmentKey].Fragment[fragmentKey].Duration
 			} else {
 				body += "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"fragment/" + strconv.Itoa(segmentKey) + "/" + strconv.Itoa(fragmentKey) + "/0qrm9ru6." + strconv.Itoa(fragmentKey) + ".m4s\"\n"
 			}
 		}
 		if element.Segments[segmentKey].Finish {
-			segmentTarget = element.Segments[segmentKey].Duration
-			body += "#EXT-X-PROGRAM-DATE-TIME:" + element.Segments[segmentKey].Time.Format("2006-01-02T15:04:05.000000Z") + "\n#EXTINF:" + strconv.FormatFloat(element.Segments[segmentKey].Duration.Seconds(), 'f', 5, 64) + ",\n"
+			//EXTINF rounded must not exceed TARGETDURATION
+			if duration := int(math.Round(element.Segments[segmentKey].Duration.Seconds())); duration > segmentTarget {
+				segmentTarget = duration
+			}
+			body += "#EXTINF:" + strconv.FormatFloat(element.Segments[segmentKey].Duration.Seconds(), 'f', 5, 64) + ",\n"
 			body += "segment/" + strconv.Itoa(segmentKey) + "/" + element.UUID + "." + strconv.Itoa(segmentKey) + ".m4s\n"
 		}
+		bodies[i] = body
+	}
+	element.SegmentTarget = segmentTarget
+	skipUntil := time.Duration(segmentTarget*SkipUntilSegments) * time.Second
+	//skip finished segments end more than CAN-SKIP-UNTIL before playlist end
+	var skipped int
+	var tail time.Duration
+	for i := len(segmentKeys) - 1; i >= 0; i-- {
+		if tail >= skipUntil && element.Segments[segmentKeys[i]].Finish {
+			skipped = i + 1
+			break
+		}
+		tail += element.Segments[segmentKeys[i]].Duration
 	}
-	header += "#EXTM3U\n"
-	header += "#EXT-X-TARGETDURATION:" + strconv.Itoa(int(math.Round(segmentTarget.Seconds()))) + "\n"
-	header += "#EXT-X-VERSION:7\n"
 	header += "#EXT-X-INDEPENDENT-SEGMENTS\n"
-	header += "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=" + strconv.FormatFloat(partTarget.Seconds()*4, 'f', 5, 64) + ",HOLD-BACK=" + strconv.FormatFloat(segmentTarget.Seconds()*4, 'f', 5, 64) + "\n"
-	header += "#EXT-X-MAP:URI=\"init.mp4\"\n"
+	header += "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,CAN-SKIP-UNTIL=" + strconv.FormatFloat(skipUntil.Seconds(), 'f', 1, 64) + ",PART-HOLD-BACK=" + strconv.FormatFloat(partTarget.Seconds()*PartHoldBackParts, 'f', 5, 64) + ",HOLD-BACK=" + strconv.Itoa(segmentTarget*HoldBackSegments) + "\n"
 	header += "#EXT-X-PART-INF:PART-TARGET=" + strconv.FormatFloat(partTarget.Seconds(), 'f', 5, 64) + "\n"
 	header += "#EXT-X-MEDIA-SEQUENCE:" + strconv.Itoa(element.MediaSequence) + "\n"
-	header += body
-	element.CacheM3U8 = header
+	header += "#EXT-X-DISCONTINUITY-SEQUENCE:" + strconv.Itoa(element.DiscontinuitySeq) + "\n"
+	top := "#EXTM3U\n"
+	top += "#EXT-X-TARGETDURATION:" + strconv.Itoa(segmentTarget) + "\n"
+	var end string
+	if element.Closed {
+		end = "#EXT-X-ENDLIST\n"
+	}
+	element.CacheM3U8 = top + "#EXT-X-VERSION:7\n" + header + strings.Join(bodies, "") + end
+	element.CacheM3U8Skip = element.CacheM3U8
+	if skipped > 0 {
+		//delta update EXT-X-SKIP need version 9, keep map of first sent segment
+		body := "#EXT-X-SKIP:SKIPPED-SEGMENTS=" + strconv.Itoa(skipped) + "\n"
+		if !strings.Contains(bodies[skipped], "#EXT-X-MAP:") {
+			body += "#EXT-X-MAP:URI=\"init/" + strconv.Itoa(element.Segments[segmentKeys[skipped]].InitID) + "/init.mp4\"\n"
+		}
+		element.CacheM3U8Skip = top + "#EXT-X-VERSION:9\n" + header + body + strings.Join(bodies[skipped:], "") + end
+	}
 	element.PlaylistUpdate()
 }
 
+//GetBandwidth peak segment bitrate in window, finished parts before first segment
+func (element *MuxerHLS) GetBandwidth() int {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	var res, size int
+	var duration time.Duration
+	for _, segment := range element.Segments {
+		if !segment.Finish {
+			for _, fragment := range segment.Fragment {
+				if fragment.Finish {
+					size += len(fragment.Data)
+					duration += fragment.Duration
+				}
+			}
+			continue
+		}
+		if segment.Duration <= 0 {
+			continue
+		}
+		if bandwidth := int(float64(segment.GetSize()*8) / segment.Duration.Seconds()); bandwidth > res {
+			res = bandwidth
+		}
+	}
+	if res == 0 && duration > 0 {
+		res = int(float64(size*8) / duration.Seconds())
+	}
+	return res
+}
+
+//MuxerStatusST muxer counters for status api
+type MuxerStatusST struct {
+	MSN          int
+	Parts        int
+	Bitrate      int
+	FPS          int
+	KeyFrameTime time.Time
+	GOPFrames    int
+	GOPDuration  time.Duration
+}
+
+//Status window counters, bitrate average over finished segments
+func (element *MuxerHLS) Status() MuxerStatusST {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	res := MuxerStatusST{
+		MSN:          element.MSN,
+		FPS:          element.FPS,
+		KeyFrameTime: element.KeyFrameTime,
+		GOPFrames:    element.GOPFrames,
+		GOPDuration:  element.GOPDuration,
+	}
+	var size int
+	var duration time.Duration
+	for _, segment := range element.Segments {
+		res.Parts += len(segment.Fragment)
+		if segment.Finish {
+			size += segment.GetSize()
+			duration += segment.Duration
+		}
+	}
+	if duration > 0 {
+		res.Bitrate = int(float64(size*8) / duration.Seconds())
+	}
+	return res
+}
+
+//MasterM3u8 master playlist with CODECS one variant
+func MasterM3u8(codecs []av.CodecData, bandwidth int) string {
+	res := "#EXTM3U\n"
+	res += "#EXT-X-VERSION:7\n"
+	res += "#EXT-X-INDEPENDENT-SEGMENTS\n"
+	res += "#EXT-X-STREAM-INF:BANDWIDTH=" + strconv.Itoa(bandwidth) + ",CODECS=\"" + codecString(codecs) + "\""
+	for _, codec := range codecs {
+		if width, height := videoSize(codec); width > 0 && height > 0 {
+			res += ",RESOLUTION=" + strconv.Itoa(width) + "x" + strconv.Itoa(height)
+			break
+		}
+	}
+	res += "\nindex.m3u8\n"
+	return res
+}
+
 //PlaylistUpdate func
 func (element *MuxerHLS) PlaylistUpdate() {
 	element.FragmentCancel()
 	element.FragmentCtx, element.FragmentCancel = context.WithCancel(context.Background())
 }
 
-//GetSegment func
-func (element *MuxerHLS) GetSegment(segment int) ([]*av.Packet, error) {
+//GetSegment func return serialized segment parts in order
+func (element *MuxerHLS) GetSegment(segment int) ([][]byte, error) {
 	element.mutex.Lock()
 	defer element.mutex.Unlock()
-	if segmentTmp, ok := element.Segments[segment]; ok && len(segmentTmp.Fragment) > 0 {
-		var res []*av.Packet
+	if segmentTmp, ok := element.Segments[segment]; ok && segmentTmp.Finish && len(segmentTmp.Fragment) > 0 {
+		var res [][]byte
 		for _, v := range element.SortFragment(segmentTmp.Fragment) {
-			res = append(res, segmentTmp.Fragment[v].Packets...)
+			if segmentTmp.Fragment[v].Data == nil {
+				return nil, ErrorStreamSegmentNotFound
+			}
+			res = append(res, segmentTmp.Fragment[v].Data)
 		}
 		return res, nil
 	}
@@ -128,14 +533,14 @@ func (element *MuxerHLS) GetSegment(segment int) ([]*av.Packet, error) {
 }
 
 //GetFragment func
-func (element *MuxerHLS) GetFragment(segment int, fragment int) ([]*av.Packet, error) {
+func (element *MuxerHLS) GetFragment(segment int, fragment int) ([]byte, error) {
 	element.mutex.Lock()
 	if segmentTmp, segmentTmpOK := element.Segments[segment]; segmentTmpOK {
 		if fragmentTmp, fragmentTmpOK := segmentTmp.Fragment[fragment]; fragmentTmpOK {
-			if fragmentTmp.Finish {
+			if fragmentTmp.Finish && fragmentTmp.Data != nil {
 				element.mutex.Unlock()
-				return fragmentTmp.Packets, nil
-			} else {
+				return fragmentTmp.Data, nil
+			} else if !fragmentTmp.Finish {
 				element.mutex.Unlock()
 				pck, err := element.WaitFragment(time.Second*1, segment, fragment)
 				if err != nil {
@@ -149,15 +554,16 @@ func (element *MuxerHLS) GetFragment(segment int, fragment int) ([]*av.Packet, e
 	return nil, ErrorStreamFragmentNotFound
 }
 
-//GetIndexM3u8 func
-func (element *MuxerHLS) GetIndexM3u8(needMSN int, needPart int) (string, error) {
+//GetIndexM3u8 func skip return delta update
+func (element *MuxerHLS) GetIndexM3u8(needMSN int, needPart int, skip bool) (string, error) {
 	element.mutex.Lock()
-	if len(element.CacheM3U8) != 0 && ((needMSN == -1 || needPart == -1) || (needMSN-element.MSN > 1) || (needMSN == element.MSN && needPart < element.CurrentFragmentID)) {
+	if len(element.CacheM3U8) != 0 && (element.Closed || needMSN == -1 || needPart == -1 || (needMSN-element.MSN > 1) || (needMSN == element.MSN && needPart < element.CurrentFragmentID)) {
+		index := element.index(skip)
 		element.mutex.Unlock()
-		return element.CacheM3U8, nil
+		return index, nil
 	} else {
 		element.mutex.Unlock()
-		index, err := element.WaitIndex(time.Second*3, needMSN, needPart)
+		index, err := element.WaitIndex(time.Second*3, needMSN, needPart, skip)
 		if err != nil {
 			return "", err
 		}
@@ -166,17 +572,19 @@ func (element *MuxerHLS) GetIndexM3u8(needMSN int, needPart int) (string, error)
 }
 
 //WaitFragment func
-func (element *MuxerHLS) WaitFragment(timeOut time.Duration, segment, fragment int) ([]*av.Packet, error) {
+func (element *MuxerHLS) WaitFragment(timeOut time.Duration, segment, fragment int) ([]byte, error) {
+	Metrics.Wait(element.UUID, "part")
 	select {
 	case <-time.After(timeOut):
+		Metrics.Timeout(element.UUID, "part")
 		return nil, ErrorStreamFragmentTimeout
 	case <-element.FragmentCtx.Done():
 		element.mutex.Lock()
 		defer element.mutex.Unlock()
 		if segmentTmp, segmentTmpOK := element.Segments[segment]; segmentTmpOK {
 			if fragmentTmp, fragmentTmpOK := segmentTmp.Fragment[fragment]; fragmentTmpOK {
-				if fragmentTmp.Finish {
-					return fragmentTmp.Packets, nil
+				if fragmentTmp.Finish && fragmentTmp.Data != nil {
+					return fragmentTmp.Data, nil
 				}
 			}
 		}
@@ -185,24 +593,35 @@ func (element *MuxerHLS) WaitFragment(timeOut time.Duration, segment, fragment i
 }
 
 //WaitIndex func
-func (element *MuxerHLS) WaitIndex(timeOut time.Duration, segment, fragment int) (string, error) {
+func (element *MuxerHLS) WaitIndex(timeOut time.Duration, segment, fragment int, skip bool) (string, error) {
+	Metrics.Wait(element.UUID, "playlist")
 	for {
 		select {
 		case <-time.After(timeOut):
+			Metrics.Timeout(element.UUID, "playlist")
 			return "", ErrorStreamIndexTimeout
 		case <-element.FragmentCtx.Done():
 			element.mutex.Lock()
-			if element.MSN < segment || (element.MSN == segment && element.CurrentFragmentID < fragment) {
+			if !element.Closed && (element.MSN < segment || (element.MSN == segment && element.CurrentFragmentID < fragment)) {
 				log.Println("wait req", element.MSN, element.CurrentFragmentID, segment, fragment)
 				element.mutex.Unlock()
 				continue
 			}
+			index := element.index(skip)
 			element.mutex.Unlock()
-			return element.CacheM3U8, nil
+			return index, nil
 		}
 	}
 }
 
+//index cached full or delta update playlist, call under lock
+func (element *MuxerHLS) index(skip bool) string {
+	if skip {
+		return element.CacheM3U8Skip
+	}
+	return element.CacheM3U8
+}
+
 //SortFragment func
 func (element *MuxerHLS) SortFragment(val map[int]*Fragment) []int {
 	keys := make([]int, len(val))
@@ -227,6 +646,14 @@ func (element *MuxerHLS) SortSegments(val map[int]*Segment) []int {
 	return keys
 }
 
+//Close finish current segment end playlist wake blocked requests
 func (element *MuxerHLS) Close() {
-
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	if element.Closed {
+		return
+	}
+	element.Closed = true
+	element.closeSegment()
+	element.UpdateIndexM3u8()
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+//1280x720 high profile level 3.1
+var (
+	testSPS = []byte{0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x03, 0xc0, 0xf1, 0x83, 0x19, 0x60}
+	testPPS = []byte{0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0}
+)
+
+//testMuxer h264 muxer 25 fps, 1s segments, 200ms parts
+func testMuxer(t *testing.T, maxSegments int) *MuxerHLS {
+	t.Helper()
+	video, err := h264parser.NewCodecDataFromSPSAndPPS(testSPS, testPPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	muxer := NewHLSMuxer("test", time.Second, maxSegments, 200*time.Millisecond)
+	muxer.SetFPS(25)
+	muxer.SetCodecs([]av.CodecData{video})
+	return muxer
+}
+
+//testWriteVideo write frames of size bytes at 25 fps, key every 25 frames
+func testWriteVideo(muxer *MuxerHLS, frames int, size int, wall time.Time) {
+	for i := 0; i < frames; i++ {
+		muxer.WritePacket(&av.Packet{Idx: 0, IsKeyFrame: i%25 == 0, Time: time.Duration(i) * 40 * time.Millisecond, Duration: 40 * time.Millisecond, Data: bytes.Repeat([]byte{1}, size)}, wall.Add(time.Duration(i)*40*time.Millisecond))
+	}
+}
+
+func TestMuxerBandwidth(t *testing.T) {
+	muxer := testMuxer(t, 6)
+	if bandwidth := muxer.GetBandwidth(); bandwidth != 0 {
+		t.Fatalf("empty bandwidth %d", bandwidth)
+	}
+	ready := make(chan struct{})
+	muxer.SetReady(ready)
+	//1000 byte frames 25 fps is 200 kbps payload, serialized adds box headers
+	testWriteVideo(muxer, 12, 1000, time.Now())
+	select {
+	case <-ready:
+	default:
+		t.Fatal("not ready after first part")
+	}
+	bandwidth := muxer.GetBandwidth()
+	if bandwidth < 200000 || bandwidth > 220000 {
+		t.Errorf("parts bandwidth %d want serialized 200-220 kbps", bandwidth)
+	}
+	testWriteVideo(muxer, 26, 1000, time.Now())
+	bandwidth = muxer.GetBandwidth()
+	if bandwidth < 200000 || bandwidth > 220000 {
+		t.Errorf("segment bandwidth %d want serialized 200-220 kbps", bandwidth)
+	}
+	master := MasterM3u8(muxer.Codecs, bandwidth)
+	if !strings.Contains(master, "BANDWIDTH="+strconv.Itoa(bandwidth)+",") {
+		t.Errorf("master %s", master)
+	}
+}
+
+func TestMuxerOptions(t *testing.T) {
+	muxer := testMuxer(t, 4)
+	testWriteVideo(muxer, 25*10, 10, time.Now())
+	index := muxer.index(false)
+	for _, want := range []string{"#EXT-X-TARGETDURATION:1\n", "#EXT-X-PART-INF:PART-TARGET=0.20000\n", "PART-HOLD-BACK=0.80000,HOLD-BACK=4\n", "#EXT-X-MEDIA-SEQUENCE:5\n"} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index missing %q\n%s", want, index)
+		}
+	}
+	//window finished segments plus open one
+	if len(muxer.Segments) != 5 || strings.Count(index, "#EXTINF:") != 4 {
+		t.Errorf("segments %d extinf %d want 5 and 4", len(muxer.Segments), strings.Count(index, "#EXTINF:"))
+	}
+	//longer parts and shorter window from next segment
+	muxer.SetOptions(2*time.Second, 4, 500*time.Millisecond)
+	testWriteVideo(muxer, 25*6, 10, time.Now())
+	index = muxer.index(false)
+	for _, want := range []string{"#EXT-X-TARGETDURATION:2\n", "#EXT-X-PART-INF:PART-TARGET=0.50000\n", "HOLD-BACK=8\n"} {
+		if !strings.Contains(index, want) {
+			t.Errorf("updated index missing %q\n%s", want, index)
+		}
+	}
+	//target duration never lower once sent
+	muxer.SetOptions(time.Second, 4, 200*time.Millisecond)
+	testWriteVideo(muxer, 25*2, 10, time.Now())
+	if index = muxer.index(false); !strings.Contains(index, "#EXT-X-TARGETDURATION:2\n") {
+		t.Errorf("target duration lowered\n%s", index)
+	}
+}
+
+func TestMuxerFragmentCache(t *testing.T) {
+	muxer := testMuxer(t, 4)
+	testWriteVideo(muxer, 25*8, 100, time.Now())
+	var size int
+	for id, segment := range muxer.Segments {
+		for part, fragment := range segment.Fragment {
+			if !fragment.Finish {
+				continue
+			}
+			//serialized once on close, packets released
+			if fragment.Data == nil || fragment.Packets != nil {
+				t.Fatalf("segment %d part %d data %d packets %d", id, part, len(fragment.Data), len(fragment.Packets))
+			}
+			buf, err := muxer.GetFragment(id, part)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if &buf[0] != &fragment.Data[0] {
+				t.Errorf("segment %d part %d served copy not cache", id, part)
+			}
+			size += len(fragment.Data)
+		}
+	}
+	//cache size count window only after eviction
+	if muxer.GetCacheSize() != size {
+		t.Errorf("cache size %d want %d", muxer.GetCacheSize(), size)
+	}
+	parts, err := muxer.GetSegment(muxer.MediaSequence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, part := range parts {
+		if &part[0] != &muxer.Segments[muxer.MediaSequence].Fragment[i].Data[0] {
+			t.Errorf("segment part %d not cache", i)
+		}
+	}
+	if _, err := muxer.GetSegment(muxer.MSN); err != ErrorStreamSegmentNotFound {
+		t.Errorf("open segment err %v", err)
+	}
+}
+
+func TestMuxerDiscontinuity(t *testing.T) {
+	muxer := testMuxer(t, 4)
+	testWriteVideo(muxer, 60, 10, time.Now())
+	//reconnect, new session time line start at zero again
+	muxer.Discontinuity()
+	testWriteVideo(muxer, 60, 10, time.Now())
+	index := muxer.index(false)
+	if strings.Count(index, "#EXT-X-DISCONTINUITY\n") != 1 || strings.Count(index, "#EXT-X-MAP:") != 1 {
+		t.Fatalf("one discontinuity one map\n%s", index)
+	}
+	//MSN continue, closed segment after reconnect marked
+	if muxer.MSN != 5 || !muxer.Segments[3].Discontinuity || muxer.Segments[2].Discontinuity {
+		t.Errorf("msn %d discontinuity %v %v", muxer.MSN, muxer.Segments[2].Discontinuity, muxer.Segments[3].Discontinuity)
+	}
+	//decode time continue over reconnect
+	if next := muxer.TrackTime[0]; next != 120*40*time.Millisecond {
+		t.Errorf("track time %v", next)
+	}
+	//codec change new init, old kept for old segments
+	video := muxer.Codecs[0]
+	audio, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes([]byte{0x12, 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	muxer.SetCodecs([]av.CodecData{video, audio})
+	testWriteVideo(muxer, 60, 10, time.Now())
+	index = muxer.index(false)
+	if muxer.InitID != 1 || !strings.Contains(index, `#EXT-X-MAP:URI="init/0/init.mp4"`) || !strings.Contains(index, `#EXT-X-MAP:URI="init/1/init.mp4"`) {
+		t.Fatalf("init %d\n%s", muxer.InitID, index)
+	}
+	if _, err := muxer.GetInit(0); err != nil {
+		t.Errorf("old init %v", err)
+	}
+	//evict old segments, discontinuity sequence count evicted discontinuities
+	testWriteVideo(muxer, 25*6, 10, time.Now())
+	index = muxer.index(false)
+	if strings.Contains(index, "init/0/") || !strings.Contains(index, "#EXT-X-DISCONTINUITY-SEQUENCE:2\n") {
+		t.Errorf("after eviction\n%s", index)
+	}
+	if _, err := muxer.GetInit(0); err != ErrorStreamInitNotFound {
+		t.Errorf("evicted init err %v", err)
+	}
+}
+
+func TestMuxerSkip(t *testing.T) {
+	muxer := testMuxer(t, 12)
+	testWriteVideo(muxer, 25*5, 10, time.Now())
+	//window shorter than CAN-SKIP-UNTIL no delta update
+	if full, _ := muxer.GetIndexM3u8(-1, -1, false); full != muxer.index(true) || !strings.Contains(full, "CAN-SKIP-UNTIL=6.0,") {
+		t.Fatalf("short window skip\n%s", muxer.index(true))
+	}
+	testWriteVideo(muxer, 25*7, 10, time.Now())
+	full, err := muxer.GetIndexM3u8(-1, -1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta, err := muxer.GetIndexM3u8(-1, -1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//11 finished one open, last 6s kept, older 5 skipped
+	if strings.Count(full, "#EXTINF:") != 11 || !strings.Contains(delta, "#EXT-X-SKIP:SKIPPED-SEGMENTS=5\n") || strings.Count(delta, "#EXTINF:") != 6 {
+		t.Fatalf("full\n%s\ndelta\n%s", full, delta)
+	}
+	if !strings.Contains(delta, "#EXT-X-VERSION:9\n") || !strings.Contains(full, "#EXT-X-VERSION:7\n") {
+		t.Error("delta update version")
+	}
+	//first kept segment keep map, tail identical
+	if !strings.Contains(delta, "#EXT-X-SKIP:SKIPPED-SEGMENTS=5\n#EXT-X-MAP:") {
+		t.Errorf("delta map missing\n%s", delta)
+	}
+	tail := full[strings.Index(full, "segment/5/"):]
+	if !strings.HasSuffix(delta, tail) {
+		t.Errorf("delta tail differ\n%s", delta)
+	}
+}
@@ -9,12 +9,16 @@ import (
 //Segment struct
 type Segment struct {
 	FPS               int               //Current fps
+	PartTarget        time.Duration     //Part target duration
 	CurrentFragment   *Fragment         //CurrentFragment link
 	CurrentFragmentID int               //CurrentFragment ID
 	Finish            bool              //Segment Ready
 	Duration          time.Duration     //Segment Duration
 	Time              time.Time         //Realtime EXT-X-PROGRAM-DATE-TIME
+	Discontinuity     bool              //First segment after source reconnect
+	InitID            int               //Init segment EXT-X-MAP id
 	Fragment          map[int]*Fragment //Fragment map
+	callback          func(*Fragment)   //Fragment close callback
 }
 
 //NewSegment func
@@ -22,7 +26,11 @@ func (element *MuxerHLS) NewSegment() *Segment {
 	res := &Segment{
 		Fragment:          make(map[int]*Fragment),
 		CurrentFragmentID: -1, //Default fragment -1
+		callback:          element.CloseFragment,
+		Discontinuity:     element.DiscontinuityNext,
+		InitID:            element.InitID,
 	}
+	element.DiscontinuityNext = false
 	//Increase MSN
 	element.MSN++
 	element.Segments[element.MSN] = res
@@ -39,17 +47,34 @@ func (element *Segment) SetFPS(fps int) {
 	element.FPS = fps
 }
 
-//WritePacket func
-func (element *Segment) WritePacket(packet *av.Packet) {
-	if element.CurrentFragment == nil || element.CurrentFragment.GetDuration().Milliseconds() >= element.FragmentMS(element.FPS) {
+//SetPartTarget func
+func (element *Segment) SetPartTarget(partTarget time.Duration) {
+	element.PartTarget = partTarget
+}
+
+//WritePacket func master track drive duration and split
+func (element *Segment) WritePacket(packet *av.Packet, master bool) {
+	//split before part overflow PART-TARGET
+	if element.CurrentFragment == nil || (master && element.CurrentFragment.GetDuration() > 0 && element.CurrentFragment.GetDuration()+packet.Duration > element.PartTarget) {
 		if element.CurrentFragment != nil {
 			element.CurrentFragment.Close()
 		}
 		element.CurrentFragmentID++
 		element.CurrentFragment = element.NewFragment()
 	}
-	element.Duration += packet.Duration
-	element.CurrentFragment.WritePacket(packet)
+	if master {
+		element.Duration += packet.Duration
+	}
+	element.CurrentFragment.WritePacket(packet, master)
+}
+
+//GetSize serialized bytes
+func (element *Segment) GetSize() int {
+	var res int
+	for _, fragment := range element.Fragment {
+		res += len(fragment.Data)
+	}
+	return res
 }
 
 //GetFragmentID func
@@ -66,7 +91,10 @@ func (element *Segment) Close() {
 }
 
 //FragmentMS func
-func (element *Segment) FragmentMS(fps int) int64 {
+func FragmentMS(fps int) int64 {
+	if fps <= 0 {
+		return 100
+	}
 	for i := 6; i >= 1; i-- {
 		if fps%i == 0 {
 			return int64(float64(1000) / float64(fps) * float64(i))
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+//Message resp struct
+type Message struct {
+	Status  int         `json:"status"`
+	Payload interface{} `json:"payload"`
+}
+
+//HTTPAPIServerStreams list all streams
+func HTTPAPIServerStreams(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, Message{Status: 1, Payload: Config.StreamsList()})
+}
+
+//HTTPAPIServerStreamInfo get stream
+func HTTPAPIServerStreamInfo(c *gin.Context) {
+	info, err := Config.StreamInfo(c.Param("uuid"))
+	if err != nil {
+		c.IndentedJSON(http.StatusNotFound, Message{Status: 0, Payload: err.Error()})
+		log.Println("HTTPAPIServerStreamInfo", c.Param("uuid"), err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, Message{Status: 1, Payload: info})
+}
+
+//HTTPAPIServerStreamAdd add stream and start worker
+func HTTPAPIServerStreamAdd(c *gin.Context) {
+	var payload StreamST
+	err := c.ShouldBindJSON(&payload)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, Message{Status: 0, Payload: err.Error()})
+		log.Println("HTTPAPIServerStreamAdd", c.Param("uuid"), err)
+		return
+	}
+	err = Config.StreamAdd(c.Param("uuid"), payload)
+	if err != nil {
+		c.IndentedJSON(httpAPIStatus(err), Message{Status: 0, Payload: err.Error()})
+		log.Println("HTTPAPIServerStreamAdd", c.Param("uuid"), err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, Message{Status: 1, Payload: Success})
+}
+
+//HTTPAPIServerStreamEdit update stream reconnect on source change
+func HTTPAPIServerStreamEdit(c *gin.Context) {
+	var payload StreamST
+	err := c.ShouldBindJSON(&payload)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, Message{Status: 0, Payload: err.Error()})
+		log.Println("HTTPAPIServerStreamEdit", c.Param("uuid"), err)
+		return
+	}
+	err = Config.StreamEdit(c.Param("uuid"), payload)
+	if err != nil {
+		c.IndentedJSON(httpAPIStatus(err), Message{Status: 0, Payload: err.Error()})
+		log.Println("HTTPAPIServerStreamEdit", c.Param("uuid"), err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, Message{Status: 1, Payload: Success})
+}
+
+//HTTPAPIServerStreamDelete stop and delete stream
+func HTTPAPIServerStreamDelete(c *gin.Context) {
+	err := Config.StreamDelete(c.Param("uuid"))
+	if err != nil {
+		c.IndentedJSON(httpAPIStatus(err), Message{Status: 0, Payload: err.Error()})
+		log.Println("HTTPAPIServerStreamDelete", c.Param("uuid"), err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, Message{Status: 1, Payload: Success})
+}
+
+//HTTPAPIServerStreamState worker state
+func HTTPAPIServerStreamState(c *gin.Context) {
+	state, err := Config.StreamState(c.Param("uuid"))
+	if err != nil {
+		c.IndentedJSON(httpAPIStatus(err), Message{Status: 0, Payload: err.Error()})
+		log.Println("HTTPAPIServerStreamState", c.Param("uuid"), err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, Message{Status: 1, Payload: state})
+}
+
+//HTTPAPIServerStreamStatus worker state and media info
+func HTTPAPIServerStreamStatus(c *gin.Context) {
+	status, err := Config.StreamStatus(c.Param("uuid"))
+	if err != nil {
+		c.IndentedJSON(httpAPIStatus(err), Message{Status: 0, Payload: err.Error()})
+		log.Println("HTTPAPIServerStreamStatus", c.Param("uuid"), err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, Message{Status: 1, Payload: status})
+}
+
+//HTTPAPIServerStreamControl worker operation start stop restart reconnect
+func HTTPAPIServerStreamControl(c *gin.Context) {
+	err := Config.StreamControl(c.Param("uuid"), c.Param("operation"))
+	if err != nil {
+		c.IndentedJSON(httpAPIStatus(err), Message{Status: 0, Payload: err.Error()})
+		log.Println("HTTPAPIServerStreamControl", c.Param("uuid"), c.Param("operation"), err)
+		return
+	}
+	state, _ := Config.StreamState(c.Param("uuid"))
+	c.IndentedJSON(http.StatusOK, Message{Status: 1, Payload: state})
+}
+
+//HTTPAPIServerStreamTimeline recorded ranges gaps and media changes, default last day
+func HTTPAPIServerStreamTimeline(c *gin.Context) {
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	var err error
+	if c.Query("from") != "" {
+		if from, err = parseTime(c.Query("from")); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, Message{Status: 0, Payload: ErrorStreamArchiveRange.Error()})
+			log.Println("HTTPAPIServerStreamTimeline", c.Param("uuid"), err)
+			return
+		}
+	}
+	if c.Query("to") != "" {
+		if to, err = parseTime(c.Query("to")); err != nil {
+			c.IndentedJSON(http.StatusBadRequest, Message{Status: 0, Payload: ErrorStreamArchiveRange.Error()})
+			log.Println("HTTPAPIServerStreamTimeline", c.Param("uuid"), err)
+			return
+		}
+	}
+	timeline, err := Config.Timeline(c.Param("uuid"), from, to)
+	if err != nil {
+		c.IndentedJSON(httpAPIStatus(err), Message{Status: 0, Payload: err.Error()})
+		log.Println("HTTPAPIServerStreamTimeline", c.Param("uuid"), err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, Message{Status: 1, Payload: timeline})
+}
+
+//HTTPAPIServerStreamExport faststart mp4 clip cut on key frames from archive or live window
+func HTTPAPIServerStreamExport(c *gin.Context) {
+	start, end, err := archiveRange(c)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, Message{Status: 0, Payload: ErrorStreamExportRange.Error()})
+		log.Println("HTTPAPIServerStreamExport", c.Param("uuid"), err)
+		return
+	}
+	export, err := Config.Export(c.Param("uuid"), start, end)
+	if err != nil {
+		c.IndentedJSON(httpAPIStatus(err), Message{Status: 0, Payload: err.Error()})
+		log.Println("HTTPAPIServerStreamExport", c.Param("uuid"), err)
+		return
+	}
+	c.Header("Content-Type", "video/mp4")
+	c.Header("Content-Length", strconv.FormatInt(export.Size, 10))
+	c.Header("Content-Disposition", "attachment; filename=\""+c.Param("uuid")+"_"+export.Start.UTC().Format("20060102T150405Z")+".mp4\"")
+	c.Header("X-Export-Start", export.Start.UTC().Format(time.RFC3339Nano))
+	c.Header("X-Export-End", export.End.UTC().Format(time.RFC3339Nano))
+	c.Status(http.StatusOK)
+	//headers sent, error only cut connection
+	if err = export.Write(c.Writer); err != nil {
+		log.Println("HTTPAPIServerStreamExport Write Error", c.Param("uuid"), err)
+	}
+}
+
+//httpAPIAuth bearer api_token or basic auth api_user, nothing configured loopback clients only
+func httpAPIAuth(c *gin.Context) {
+	token, user, password := Config.apiAuth()
+	if token == "" && user == "" {
+		//remote addr not X-Forwarded-For, header set by any client
+		host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+		if ip := net.ParseIP(host); err == nil && ip != nil && ip.IsLoopback() {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, Message{Status: 0, Payload: ErrorStreamAPIForbidden.Error()})
+		return
+	}
+	if auth := c.GetHeader("Authorization"); token != "" && strings.HasPrefix(auth, "Bearer ") && secureEqual(strings.TrimPrefix(auth, "Bearer "), token) {
+		c.Next()
+		return
+	}
+	if name, pass, ok := c.Request.BasicAuth(); user != "" && ok && secureEqual(name, user) && secureEqual(pass, password) {
+		c.Next()
+		return
+	}
+	if user != "" {
+		c.Header("WWW-Authenticate", `Basic realm="api"`)
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, Message{Status: 0, Payload: ErrorStreamAPIUnauthorized.Error()})
+}
+
+//httpArchiveAuth recorded footage need api auth unless archive_public set
+func httpArchiveAuth(c *gin.Context) {
+	if Config.archivePublic() {
+		c.Next()
+		return
+	}
+	httpAPIAuth(c)
+}
+
+//secureEqual constant time compare
+func secureEqual(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+//httpAPIStatus http status for config error
+func httpAPIStatus(err error) int {
+	switch err {
+	case ErrorStreamNotFound, ErrorStreamArchiveNotFound:
+		return http.StatusNotFound
+	case ErrorStreamAlreadyExists:
+		return http.StatusConflict
+	case ErrorStreamShutdown:
+		return http.StatusServiceUnavailable
+	case ErrorStreamHlsOptionsNegative, ErrorStreamPartTargetTooLong, ErrorStreamWindowTooShort, ErrorStreamReconnectInvalid, ErrorStreamSourceInvalid, ErrorStreamWebhookInvalid, ErrorStreamRetentionNegative, ErrorStreamArchiveRange, ErrorStreamExportRange, ErrorStreamExportCodecChange, ErrorStreamOperationUnknown, ErrorStreamUUIDInvalid:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -6,14 +6,34 @@ import (
 	"sort"
 	"time"
 
-	"github.com/deepch/vdk/format/mp4f"
 	"github.com/gin-contrib/cors"
-	"github.com/gin-gonic/autotls"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/acme/autocert"
 )
 
-//serveHTTP func
-func serveHTTP() {
+//serveHTTP func start servers return them for graceful shutdown
+func serveHTTP() []*http.Server {
+	router := newRouter()
+	httpsServer := &http.Server{Handler: router}
+	go func() {
+		//same as autotls.Run server kept for Shutdown
+		err := httpsServer.Serve(autocert.NewListener(Config.HttpName() + Config.HttpsPort()))
+		if err != nil && err != http.ErrServerClosed {
+			log.Println("Start HTTPS Server Error", err)
+		}
+	}()
+	httpServer := &http.Server{Addr: Config.HttpPort(), Handler: router}
+	go func() {
+		err := httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Println("Start HTTP Server Error", err)
+		}
+	}()
+	return []*http.Server{httpsServer, httpServer}
+}
+
+//newRouter player, hls and api routes
+func newRouter() *gin.Engine {
 	router := gin.New()
 	router.Use(cors.Default())
 	//router.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedExtensions([]string{".mp4", ".m4s"})))
@@ -39,21 +59,28 @@ func serveHTTP() {
 			"version":  time.Now().String(),
 		})
 	})
-	router.GET("/play/hls/:uuid/index.m3u8", HttpHlsIndex)
-	router.GET("/play/hls/:uuid/init.mp4", HttpHlsInit)
-	router.GET("/play/hls/:uuid/segment/:segment/:any", HttpHlsSegment)
-	router.GET("/play/hls/:uuid/fragment/:segment/:fragment/:any", HttpHlsFragment)
+	router.GET("/play/hls/:uuid/master.m3u8", metricsRequest("master"), HttpHlsMaster)
+	router.GET("/play/hls/:uuid/index.m3u8", metricsRequest("playlist"), HttpHlsIndex)
+	router.GET("/play/hls/:uuid/init.mp4", metricsRequest("init"), HttpHlsInit)
+	router.GET("/play/hls/:uuid/init/:init/init.mp4", metricsRequest("init"), HttpHlsInit)
+	router.GET("/play/hls/:uuid/segment/:segment/:any", metricsRequest("segment"), HttpHlsSegment)
+	router.GET("/play/hls/:uuid/fragment/:segment/:fragment/:any", metricsRequest("part"), HttpHlsFragment)
+	router.GET("/play/hls/:uuid/archive.m3u8", httpArchiveAuth, metricsRequest("archive"), HttpHlsArchive)
+	router.GET("/play/hls/:uuid/archive/:day/:file", httpArchiveAuth, metricsRequest("archive_segment"), HttpHlsArchiveFile)
+	router.GET("/metrics", HTTPMetrics)
+	api := router.Group("/api", httpAPIAuth)
+	api.GET("/streams", HTTPAPIServerStreams)
+	api.GET("/streams/:uuid", HTTPAPIServerStreamInfo)
+	api.POST("/streams/:uuid", HTTPAPIServerStreamAdd)
+	api.PUT("/streams/:uuid", HTTPAPIServerStreamEdit)
+	api.DELETE("/streams/:uuid", HTTPAPIServerStreamDelete)
+	api.GET("/streams/:uuid/state", HTTPAPIServerStreamState)
+	api.GET("/streams/:uuid/status", HTTPAPIServerStreamStatus)
+	api.GET("/streams/:uuid/timeline", HTTPAPIServerStreamTimeline)
+	api.GET("/streams/:uuid/export.mp4", HTTPAPIServerStreamExport)
+	api.POST("/streams/:uuid/:operation", HTTPAPIServerStreamControl)
 	router.StaticFS("/static", http.Dir("web/static"))
-	go func() {
-		err := autotls.Run(router, Config.HttpName()+Config.HttpsPort())
-		if err != nil {
-			log.Println("Start HTTPS Server Error", err)
-		}
-	}()
-	err := router.Run(Config.HttpPort())
-	if err != nil {
-		log.Println("Start HTTP Server Error", err)
-	}
+	return router
 }
 
 //HttpHlsInit func
@@ -64,24 +91,55 @@ func HttpHlsInit(c *gin.Context) {
 	}
 	Config.RunIFNotRun(c.Param("uuid"))
 	c.Header("Content-Type", "video/mp4")
-	codecs := Config.coGe(c.Param("uuid"))
-	if codecs == nil {
-		log.Println("HttpHlsInit Codec Error")
+	if err := Config.HLSMuxerWaitReady(c.Request.Context(), c.Param("uuid"), OnDemandStartTimeout); err != nil {
+		log.Println("HttpHlsInit HLSMuxerWaitReady Error", err)
 		return
 	}
-	Muxer := mp4f.NewMuxer(nil)
-	err := Muxer.WriteHeader(codecs)
+	//init id from EXT-X-MAP old window segments keep old init
+	id := -1
+	if c.Param("init") != "" {
+		id = stringToInt(c.Param("init"))
+	}
+	buf, err := Config.HLSMuxerInit(c.Param("uuid"), id)
 	if err != nil {
-		log.Println("HttpHlsInit WriteHeader Error", err)
+		log.Println("HttpHlsInit HLSMuxerInit Error", err)
 		return
 	}
-	_, buf := Muxer.GetInit(codecs)
 	_, err = c.Writer.Write(buf)
 	if err != nil {
 		log.Println("HttpHlsInit Write Error", err)
 	}
 }
 
+//HttpHlsMaster func
+func HttpHlsMaster(c *gin.Context) {
+	c.Header("Content-Type", "application/vnd.apple.mpegurl")
+	if !Config.ext(c.Param("uuid")) {
+		log.Println("HttpHlsMaster", c.Param("uuid"), ErrorStreamNotFound)
+		return
+	}
+	Config.RunIFNotRun(c.Param("uuid"))
+	if err := Config.HLSMuxerWaitReady(c.Request.Context(), c.Param("uuid"), OnDemandStartTimeout); err != nil {
+		log.Println("HttpHlsMaster HLSMuxerWaitReady Error", err)
+		return
+	}
+	codecs := Config.coGe(c.Param("uuid"))
+	if codecs == nil {
+		log.Println("HttpHlsMaster Codec Error")
+		return
+	}
+	//measured from serialized parts, ready mean first part closed
+	bandwidth := Config.HLSMuxerBandwidth(c.Param("uuid"))
+	if bandwidth == 0 {
+		log.Println("HttpHlsMaster Bandwidth Error")
+		return
+	}
+	_, err := c.Writer.Write([]byte(MasterM3u8(codecs, bandwidth)))
+	if err != nil {
+		log.Println("HttpHlsMaster Write Error", err)
+	}
+}
+
 //HttpHlsIndex func
 func HttpHlsIndex(c *gin.Context) {
 	c.Header("Content-Type", "application/vnd.apple.mpegurl")
@@ -89,7 +147,14 @@ func HttpHlsIndex(c *gin.Context) {
 		log.Println("HttpHlsIndex", c.Param("uuid"), ErrorStreamNotFound)
 		return
 	}
-	index, err := Config.HLSMuxerM3U8(c.Param("uuid"), stringToInt(c.DefaultQuery("_HLS_msn", "-1")), stringToInt(c.DefaultQuery("_HLS_part", "-1")))
+	Config.RunIFNotRun(c.Param("uuid"))
+	if err := Config.HLSMuxerWaitReady(c.Request.Context(), c.Param("uuid"), OnDemandStartTimeout); err != nil {
+		log.Println("HttpHlsIndex HLSMuxerWaitReady Error", err)
+		return
+	}
+	//_HLS_skip v2 same as YES no date ranges to skip
+	skip := c.Query("_HLS_skip") == "YES" || c.Query("_HLS_skip") == "v2"
+	index, err := Config.HLSMuxerM3U8(c.Param("uuid"), stringToInt(c.DefaultQuery("_HLS_msn", "-1")), stringToInt(c.DefaultQuery("_HLS_part", "-1")), skip)
 	if err != nil {
 		log.Println("HttpHlsIndex HLSMuxerM3U8 Error", err)
 		return
@@ -111,39 +176,24 @@ func HttpHlsSegment(c *gin.Context) {
 		log.Println("HttpHlsSegment", c.Param("uuid"), ErrorStreamNotFound)
 		return
 	}
-	codecs := Config.coGe(c.Param("uuid"))
-	if codecs == nil {
-		log.Println("HttpHlsSegment Codec Error")
-		return
-	}
-	Muxer := mp4f.NewMuxer(nil)
-	err := Muxer.WriteHeader(codecs)
-	if err != nil {
-		log.Println("HttpHlsSegment WriteHeader Error", err)
-		return
-	}
+	Config.ack(c.Param("uuid"))
 	seqData, err := Config.HLSMuxerSegment(c.Param("uuid"), stringToInt(c.Param("segment")))
 	if err != nil {
 		log.Println("HttpHlsSegment HLSMuxerSegment Error", err)
 		return
 	}
-	for _, v := range seqData {
-		err = Muxer.WritePacket4(*v)
+	//segment is cached parts joined keep part sequence and decode time
+	for _, buf := range seqData {
+		_, err = c.Writer.Write(buf)
 		if err != nil {
-			log.Println("HttpHlsSegment WritePacket4 Error", err)
+			if err.Error() == "http2: stream closed" {
+				log.Println("HttpHlsSegment Write Browser Close Stream")
+			} else {
+				log.Println("HttpHlsSegment Writer Error", err)
+			}
 			return
 		}
 	}
-	buf := Muxer.Finalize()
-	_, err = c.Writer.Write(buf)
-	if err != nil {
-		if err.Error() == "http2: stream closed" {
-			log.Println("HttpHlsSegment Write Browser Close Stream")
-		} else {
-			log.Println("HttpHlsSegment Writer Error", err)
-		}
-		return
-	}
 }
 func HttpHlsFragment(c *gin.Context) {
 	c.Header("Content-Type", "video/mp4")
@@ -151,30 +201,12 @@ func HttpHlsFragment(c *gin.Context) {
 		log.Println("HttpHlsFragment", c.Param("uuid"), ErrorStreamNotFound)
 		return
 	}
-	codecs := Config.coGe(c.Param("uuid"))
-	if codecs == nil {
-		log.Println("HttpHlsFragment Codec Error")
-		return
-	}
-	Muxer := mp4f.NewMuxer(nil)
-	err := Muxer.WriteHeader(codecs)
-	if err != nil {
-		log.Println("HttpHlsFragment WriteHeader Error", err)
-		return
-	}
-	seqData, err := Config.HLSMuxerFragment(c.Param("uuid"), stringToInt(c.Param("segment")), stringToInt(c.Param("fragment")))
+	Config.ack(c.Param("uuid"))
+	buf, err := Config.HLSMuxerFragment(c.Param("uuid"), stringToInt(c.Param("segment")), stringToInt(c.Param("fragment")))
 	if err != nil {
 		log.Println("HttpHlsFragment HLSMuxerFragment Error", err)
 		return
 	}
-	for _, v := range seqData {
-		err = Muxer.WritePacket4(*v)
-		if err != nil {
-			log.Println("HttpHlsFragment WritePacket4 Error", err)
-			return
-		}
-	}
-	buf := Muxer.Finalize()
 	_, err = c.Writer.Write(buf)
 	if err != nil {
 		if err.Error() == "http2: stream closed" {
@@ -185,3 +217,55 @@ func HttpHlsFragment(c *gin.Context) {
 		return
 	}
 }
+
+//archiveRange start required end default now
+func archiveRange(c *gin.Context) (time.Time, time.Time, error) {
+	start, err := parseTime(c.Query("start"))
+	if err != nil {
+		return time.Time{}, time.Time{}, ErrorStreamArchiveRange
+	}
+	end := time.Now()
+	if c.Query("end") != "" {
+		end, err = parseTime(c.Query("end"))
+		if err != nil {
+			return time.Time{}, time.Time{}, ErrorStreamArchiveRange
+		}
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, ErrorStreamArchiveRange
+	}
+	return start, end, nil
+}
+
+//HttpHlsArchive VOD playlist over recorded segments
+func HttpHlsArchive(c *gin.Context) {
+	start, end, err := archiveRange(c)
+	if err != nil {
+		c.Status(http.StatusBadRequest)
+		log.Println("HttpHlsArchive", c.Param("uuid"), err)
+		return
+	}
+	files, err := Config.Archive(c.Param("uuid"), start, end)
+	if err != nil {
+		c.Status(httpAPIStatus(err))
+		log.Println("HttpHlsArchive", c.Param("uuid"), err)
+		return
+	}
+	c.Header("Content-Type", "application/vnd.apple.mpegurl")
+	_, err = c.Writer.Write([]byte(ArchiveM3u8(files)))
+	if err != nil {
+		log.Println("HttpHlsArchive Write Error", err)
+	}
+}
+
+//HttpHlsArchiveFile recorded segment or init
+func HttpHlsArchiveFile(c *gin.Context) {
+	path, err := Config.ArchiveFile(c.Param("uuid"), c.Param("day"), c.Param("file"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		log.Println("HttpHlsArchiveFile", c.Param("uuid"), err)
+		return
+	}
+	c.Header("Content-Type", "video/mp4")
+	c.File(path)
+}
@@ -1,15 +1,34 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
+//ShutdownTimeout deadline drain viewers and close RTSP sessions
+const ShutdownTimeout = 10 * time.Second
+
 func main() {
-	go serveHTTP()
+	servers := serveHTTP()
 	go serveStreams()
+	//SIGHUP reload config diff streams keep untouched running
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("SIGHUP Reload Config")
+			Config.ReloadConfig()
+		}
+	}()
+	if Config.Server.ConfigWatch {
+		go Config.watchConfig()
+	}
+	go Config.retention()
 	sig := make(chan os.Signal, 1)
 	done := make(chan bool, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
@@ -20,5 +39,35 @@ func main() {
 	}()
 	log.Println("Server Start Awaiting Signal")
 	<-done
-	log.Println("Exiting")
+	log.Println("Shutdown Start")
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	//stop accept, in flight requests finish after playlists end
+	errs := make(chan error, len(servers)+1)
+	for _, server := range servers {
+		go func(server *http.Server) {
+			errs <- server.Shutdown(ctx)
+		}(server)
+	}
+	go func() {
+		err := Config.Shutdown(ctx)
+		if err == nil {
+			//last segments closed on playlist end
+			err = Recorder.Flush(ctx)
+		}
+		errs <- err
+	}()
+	code := 0
+	for i := 0; i < len(servers)+1; i++ {
+		if err := <-errs; err != nil {
+			log.Println("Shutdown Error", err)
+			code = 1
+		}
+	}
+	//offline events of stopped streams, target down not shutdown error
+	if err := Webhook.Flush(ctx); err != nil {
+		log.Println("Shutdown Webhook Pending Dropped", err)
+	}
+	cancel()
+	log.Println("Exiting", code)
+	os.Exit(code)
 }
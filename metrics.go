@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const ViewerIdle = 10 * time.Second //client without request is not viewer
+
+var (
+	metricsSegmentBuckets = []float64{0.5, 1, 2, 3, 4, 6, 8, 10, 15}
+	metricsPartBuckets    = []float64{0.05, 0.1, 0.2, 0.3, 0.5, 1, 2}
+	metricsLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+//Metrics global prometheus counters
+var Metrics = NewMetrics()
+
+//MetricsST per stream counters
+type MetricsST struct {
+	mutex          sync.Mutex
+	Streams        map[string]*StreamMetricsST
+	RetentionFiles map[string]uint64 //Removed segments per reason
+	RetentionBytes map[string]uint64 //Removed bytes per reason
+}
+
+//StreamMetricsST stream counters and histograms
+type StreamMetricsST struct {
+	Reconnects      uint64                //Source reconnects
+	Packets         uint64                //Packets ingested
+	Bytes           uint64                //Bytes ingested
+	SegmentDuration *Histogram            //Closed segment duration
+	PartDuration    *Histogram            //Closed part duration
+	Requests        map[string]*Histogram //Request latency per type
+	Waits           map[string]uint64     //Blocking waits per type
+	Timeouts        map[string]uint64     //Blocking wait timeouts per type
+	Viewers         map[string]time.Time  //Client last request
+	RecordBytes     int64                 //Footage on disk after last retention pass
+}
+
+//Histogram prometheus histogram cumulative on render
+type Histogram struct {
+	Buckets []float64
+	Counts  []uint64
+	Sum     float64
+	Count   uint64
+}
+
+//NewMetrics empty registry
+func NewMetrics() *MetricsST {
+	return &MetricsST{
+		Streams:        make(map[string]*StreamMetricsST),
+		RetentionFiles: make(map[string]uint64),
+		RetentionBytes: make(map[string]uint64),
+	}
+}
+
+//NewHistogram histogram with upper bounds
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{Buckets: buckets, Counts: make([]uint64, len(buckets))}
+}
+
+//Observe add value
+func (element *Histogram) Observe(val float64) {
+	for i, bound := range element.Buckets {
+		if val <= bound {
+			element.Counts[i]++
+		}
+	}
+	element.Sum += val
+	element.Count++
+}
+
+//write histogram lines with labels
+func (element *Histogram) write(res *strings.Builder, name string, labels string) {
+	for i, bound := range element.Buckets {
+		fmt.Fprintf(res, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'f', -1, 64), element.Counts[i])
+	}
+	fmt.Fprintf(res, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, element.Count)
+	fmt.Fprintf(res, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(element.Sum, 'f', -1, 64))
+	fmt.Fprintf(res, "%s_count{%s} %d\n", name, labels, element.Count)
+}
+
+//stream counters create on first use, call under lock
+func (element *MetricsST) stream(uuid string) *StreamMetricsST {
+	tmp, ok := element.Streams[uuid]
+	if !ok {
+		tmp = &StreamMetricsST{
+			SegmentDuration: NewHistogram(metricsSegmentBuckets),
+			PartDuration:    NewHistogram(metricsPartBuckets),
+			Requests:        make(map[string]*Histogram),
+			Waits:           make(map[string]uint64),
+			Timeouts:        make(map[string]uint64),
+			Viewers:         make(map[string]time.Time),
+		}
+		element.Streams[uuid] = tmp
+	}
+	return tmp
+}
+
+//Reconnect count source reconnect
+func (element *MetricsST) Reconnect(uuid string) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	element.stream(uuid).Reconnects++
+}
+
+//Packet count ingested packet
+func (element *MetricsST) Packet(uuid string, size int) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	tmp := element.stream(uuid)
+	tmp.Packets++
+	tmp.Bytes += uint64(size)
+}
+
+//Segment observe closed segment duration
+func (element *MetricsST) Segment(uuid string, duration time.Duration) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	element.stream(uuid).SegmentDuration.Observe(duration.Seconds())
+}
+
+//Part observe closed part duration
+func (element *MetricsST) Part(uuid string, duration time.Duration) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	element.stream(uuid).PartDuration.Observe(duration.Seconds())
+}
+
+//Wait count blocking playlist or part wait
+func (element *MetricsST) Wait(uuid string, kind string) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	element.stream(uuid).Waits[kind]++
+}
+
+//Timeout count blocking wait timeout
+func (element *MetricsST) Timeout(uuid string, kind string) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	element.stream(uuid).Timeouts[kind]++
+}
+
+//Request observe request latency and mark client as viewer
+func (element *MetricsST) Request(uuid string, kind string, latency time.Duration, client string) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	tmp := element.stream(uuid)
+	if _, ok := tmp.Requests[kind]; !ok {
+		tmp.Requests[kind] = NewHistogram(metricsLatencyBuckets)
+	}
+	tmp.Requests[kind].Observe(latency.Seconds())
+	if _, ok := tmp.Viewers[client]; !ok {
+		//new client, idle drop keep map bounded without scrape
+		tmp.pruneViewers()
+	}
+	tmp.Viewers[client] = time.Now()
+}
+
+//pruneViewers drop idle clients, call under lock
+func (element *StreamMetricsST) pruneViewers() {
+	for client, last := range element.Viewers {
+		if time.Since(last) > ViewerIdle {
+			delete(element.Viewers, client)
+		}
+	}
+}
+
+//RecordBytes set stream footage size
+func (element *MetricsST) RecordBytes(uuid string, size int64) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	element.stream(uuid).RecordBytes = size
+}
+
+//Retention count removed segment
+func (element *MetricsST) Retention(reason string, size int64) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	element.RetentionFiles[reason]++
+	element.RetentionBytes[reason] += uint64(size)
+}
+
+//Render prometheus text format, deleted streams dropped
+func (element *MetricsST) Render() string {
+	_, all := Config.list()
+	sort.Strings(all)
+	states := make(map[string]StreamStatusST)
+	for _, uuid := range all {
+		if status, err := Config.StreamStatus(uuid); err == nil {
+			states[uuid] = status
+		}
+	}
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	for uuid := range element.Streams {
+		if _, ok := states[uuid]; !ok {
+			delete(element.Streams, uuid)
+		}
+	}
+	for _, uuid := range all {
+		element.stream(uuid).pruneViewers()
+	}
+	var res strings.Builder
+	family := func(name string, kind string, help string) {
+		fmt.Fprintf(&res, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	family("hlsll_stream_up", "gauge", "Stream worker online 1 or 0")
+	for _, uuid := range all {
+		var up int
+		if states[uuid].State == StreamStateOnline {
+			up = 1
+		}
+		fmt.Fprintf(&res, "hlsll_stream_up{stream=%q} %d\n", uuid, up)
+	}
+	family("hlsll_stream_reconnects_total", "counter", "Stream source reconnects")
+	for _, uuid := range all {
+		fmt.Fprintf(&res, "hlsll_stream_reconnects_total{stream=%q} %d\n", uuid, element.Streams[uuid].Reconnects)
+	}
+	family("hlsll_stream_packets_total", "counter", "Stream packets ingested")
+	for _, uuid := range all {
+		fmt.Fprintf(&res, "hlsll_stream_packets_total{stream=%q} %d\n", uuid, element.Streams[uuid].Packets)
+	}
+	family("hlsll_stream_bytes_total", "counter", "Stream bytes ingested")
+	for _, uuid := range all {
+		fmt.Fprintf(&res, "hlsll_stream_bytes_total{stream=%q} %d\n", uuid, element.Streams[uuid].Bytes)
+	}
+	family("hlsll_stream_keyframe_interval_seconds", "gauge", "Stream last video key frame interval")
+	for _, uuid := range all {
+		fmt.Fprintf(&res, "hlsll_stream_keyframe_interval_seconds{stream=%q} %s\n", uuid, strconv.FormatFloat(float64(states[uuid].GOPMS)/1000, 'f', -1, 64))
+	}
+	family("hlsll_stream_viewers", "gauge", "Stream clients with request in last 10 seconds")
+	for _, uuid := range all {
+		fmt.Fprintf(&res, "hlsll_stream_viewers{stream=%q} %d\n", uuid, len(element.Streams[uuid].Viewers))
+	}
+	family("hlsll_segment_duration_seconds", "histogram", "Closed segment duration")
+	for _, uuid := range all {
+		element.Streams[uuid].SegmentDuration.write(&res, "hlsll_segment_duration_seconds", fmt.Sprintf("stream=%q", uuid))
+	}
+	family("hlsll_part_duration_seconds", "histogram", "Closed part duration")
+	for _, uuid := range all {
+		element.Streams[uuid].PartDuration.write(&res, "hlsll_part_duration_seconds", fmt.Sprintf("stream=%q", uuid))
+	}
+	family("hlsll_http_request_duration_seconds", "histogram", "Request latency by type master playlist init segment part, count is request count")
+	for _, uuid := range all {
+		for _, kind := range sortedKeys(element.Streams[uuid].Requests) {
+			element.Streams[uuid].Requests[kind].write(&res, "hlsll_http_request_duration_seconds", fmt.Sprintf("stream=%q,type=%q", uuid, kind))
+		}
+	}
+	family("hlsll_blocking_waits_total", "counter", "Blocking playlist reload and part waits")
+	for _, uuid := range all {
+		for _, kind := range sortedKeys(element.Streams[uuid].Waits) {
+			fmt.Fprintf(&res, "hlsll_blocking_waits_total{stream=%q,type=%q} %d\n", uuid, kind, element.Streams[uuid].Waits[kind])
+		}
+	}
+	family("hlsll_blocking_timeouts_total", "counter", "Blocking waits ended by timeout")
+	for _, uuid := range all {
+		for _, kind := range sortedKeys(element.Streams[uuid].Timeouts) {
+			fmt.Fprintf(&res, "hlsll_blocking_timeouts_total{stream=%q,type=%q} %d\n", uuid, kind, element.Streams[uuid].Timeouts[kind])
+		}
+	}
+	family("hlsll_record_bytes", "gauge", "Stream footage on disk after last retention pass")
+	for _, uuid := range all {
+		fmt.Fprintf(&res, "hlsll_record_bytes{stream=%q} %d\n", uuid, element.Streams[uuid].RecordBytes)
+	}
+	family("hlsll_retention_removed_segments_total", "counter", "Recorded segments removed by retention reason age size disk")
+	for _, reason := range sortedKeys(element.RetentionFiles) {
+		fmt.Fprintf(&res, "hlsll_retention_removed_segments_total{reason=%q} %d\n", reason, element.RetentionFiles[reason])
+	}
+	family("hlsll_retention_removed_bytes_total", "counter", "Recorded bytes removed by retention reason age size disk")
+	for _, reason := range sortedKeys(element.RetentionBytes) {
+		fmt.Fprintf(&res, "hlsll_retention_removed_bytes_total{reason=%q} %d\n", reason, element.RetentionBytes[reason])
+	}
+	return res.String()
+}
+
+//sortedKeys map keys in order, stable render
+func sortedKeys(val interface{}) []string {
+	var res []string
+	switch tmp := val.(type) {
+	case map[string]*Histogram:
+		for k := range tmp {
+			res = append(res, k)
+		}
+	case map[string]uint64:
+		for k := range tmp {
+			res = append(res, k)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+//metricsRequest observe request latency by type on known streams
+func metricsRequest(kind string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		if Config.ext(c.Param("uuid")) {
+			Metrics.Request(c.Param("uuid"), kind, time.Since(start), c.ClientIP()+" "+c.Request.UserAgent())
+		}
+	}
+}
+
+//HTTPMetrics prometheus scrape
+func HTTPMetrics(c *gin.Context) {
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(Metrics.Render()))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHistogram(t *testing.T) {
+	histogram := NewHistogram([]float64{0.1, 1})
+	for _, val := range []float64{0.05, 0.1, 0.3, 3} {
+		histogram.Observe(val)
+	}
+	var res strings.Builder
+	histogram.write(&res, "test", `stream="a"`)
+	want := `test_bucket{stream="a",le="0.1"} 2
+test_bucket{stream="a",le="1"} 3
+test_bucket{stream="a",le="+Inf"} 4
+test_sum{stream="a"} 3.45
+test_count{stream="a"} 4
+`
+	if res.String() != want {
+		t.Errorf("histogram\n%s", res.String())
+	}
+}
+
+func TestMetricsRender(t *testing.T) {
+	testStream(t, "test-metrics", StreamST{URL: "rtsp://127.0.0.1:1/test", OnDemand: true})
+	metrics := NewMetrics()
+	metrics.Reconnect("test-metrics")
+	metrics.Packet("test-metrics", 100)
+	metrics.Packet("test-metrics", 50)
+	metrics.Segment("test-metrics", 2*time.Second)
+	metrics.Wait("test-metrics", "part")
+	metrics.Wait("test-metrics", "part")
+	metrics.Timeout("test-metrics", "part")
+	metrics.Request("test-metrics", "segment", 20*time.Millisecond, "10.0.0.1 a")
+	metrics.Request("test-metrics", "part", 20*time.Millisecond, "10.0.0.2 b")
+	metrics.Retention("age", 1000)
+	//idle viewer and deleted stream dropped on render
+	metrics.Streams["test-metrics"].Viewers["10.0.0.3 c"] = time.Now().Add(-ViewerIdle - time.Second)
+	metrics.Reconnect("test-deleted")
+	res := metrics.Render()
+	for _, want := range []string{
+		"# TYPE hlsll_stream_up gauge\n",
+		`hlsll_stream_up{stream="test-metrics"} 0` + "\n",
+		`hlsll_stream_reconnects_total{stream="test-metrics"} 1` + "\n",
+		`hlsll_stream_packets_total{stream="test-metrics"} 2` + "\n",
+		`hlsll_stream_bytes_total{stream="test-metrics"} 150` + "\n",
+		`hlsll_stream_viewers{stream="test-metrics"} 2` + "\n",
+		`hlsll_segment_duration_seconds_bucket{stream="test-metrics",le="1"} 0` + "\n",
+		`hlsll_segment_duration_seconds_bucket{stream="test-metrics",le="2"} 1` + "\n",
+		`hlsll_http_request_duration_seconds_count{stream="test-metrics",type="part"} 1` + "\n",
+		`hlsll_blocking_waits_total{stream="test-metrics",type="part"} 2` + "\n",
+		`hlsll_blocking_timeouts_total{stream="test-metrics",type="part"} 1` + "\n",
+		`hlsll_retention_removed_bytes_total{reason="age"} 1000` + "\n",
+	} {
+		if !strings.Contains(res, want) {
+			t.Errorf("render missing %q", want)
+		}
+	}
+	if strings.Contains(res, "test-deleted") || metrics.Streams["test-deleted"] != nil {
+		t.Error("deleted stream rendered")
+	}
+}
+
+func TestMetricsRequest(t *testing.T) {
+	testStream(t, "test-request", StreamST{URL: "rtsp://127.0.0.1:1/test", OnDemand: true})
+	router := gin.New()
+	router.GET("/play/hls/:uuid/index.m3u8", metricsRequest("playlist"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	for _, target := range []string{"/play/hls/test-request/index.m3u8", "/play/hls/test-request/index.m3u8", "/play/hls/test-unknown/index.m3u8"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	Metrics.mutex.Lock()
+	defer Metrics.mutex.Unlock()
+	//unknown stream not counted, same client one viewer
+	if tmp := Metrics.Streams["test-request"]; tmp == nil || tmp.Requests["playlist"].Count != 2 || len(tmp.Viewers) != 1 {
+		t.Errorf("request metrics %+v", tmp)
+	}
+	if Metrics.Streams["test-unknown"] != nil {
+		t.Error("unknown stream counted")
+	}
+}
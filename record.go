@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultRecordPath = "records" //record root stream/date/segments
+	RecordQueueSize   = 256       //segments wait slow disk, full queue drop
+	RecordDateLayout  = "2006-01-02"
+)
+
+//Recorder global segment writer
+var Recorder = NewRecorder()
+
+//RecorderST write closed segments off ingest path
+type RecorderST struct {
+	pending PendingST //queued and writing segments
+	queue   chan RecordSegmentST
+}
+
+//RecordSegmentST closed segment with init for disk
+type RecordSegmentST struct {
+	Path     string        //Stream record root
+	UUID     string        //Stream UUID
+	Time     time.Time     //Segment wall clock start
+	Duration time.Duration //Segment duration
+	Init     []byte        //Init segment for segment codecs
+	Data     [][]byte      //Parts in order
+	Codecs   string        //RFC 6381 codecs for index
+	Width    int           //Video width for index
+	Height   int           //Video height for index
+}
+
+//RecordFileST recorded segment on disk
+type RecordFileST struct {
+	Path     string        //Segment file
+	Stream   string        //Stream UUID
+	Time     time.Time     //Segment wall clock start
+	Duration time.Duration //Segment duration
+	Init     string        //Init file for segment
+	Size     int64         //File size
+}
+
+//NewRecorder start writer
+func NewRecorder() *RecorderST {
+	res := &RecorderST{queue: make(chan RecordSegmentST, RecordQueueSize)}
+	go res.writer()
+	return res
+}
+
+//Write queue segment never block ingest, full queue drop
+func (element *RecorderST) Write(segment RecordSegmentST) {
+	element.pending.Add(1)
+	select {
+	case element.queue <- segment:
+	default:
+		element.pending.Done()
+		log.Println(segment.UUID, "Record Queue Full Drop Segment", segment.Time)
+	}
+}
+
+//writer write queued segments in order
+func (element *RecorderST) writer() {
+	for segment := range element.queue {
+		if err := segment.write(); err != nil {
+			log.Println(segment.UUID, "Record Write Error", err)
+		}
+		element.pending.Done()
+	}
+}
+
+//Flush wait queued segments written or deadline
+func (element *RecorderST) Flush(ctx context.Context) error {
+	return element.pending.Wait(ctx)
+}
+
+//write init once per day and codecs then segment and index line, file names
+//init_<crc32>.mp4 and <start unix ms>_<duration ms>_<init crc32>.m4s
+func (element *RecordSegmentST) write() error {
+	dir := filepath.Join(element.Path, element.UUID, element.Time.UTC().Format(RecordDateLayout))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	crc := crc32.ChecksumIEEE(element.Init)
+	initName := filepath.Join(dir, fmt.Sprintf("init_%08x.mp4", crc))
+	if _, err = os.Stat(initName); os.IsNotExist(err) {
+		err = writeFileAtomic(initName, [][]byte{element.Init})
+		if err != nil {
+			return err
+		}
+	}
+	start := element.Time.UnixNano() / int64(time.Millisecond)
+	name := fmt.Sprintf("%d_%d_%08x.m4s", start, element.Duration.Milliseconds(), crc)
+	err = writeFileAtomic(filepath.Join(dir, name), element.Data)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, buf := range element.Data {
+		size += int64(len(buf))
+	}
+	return recordIndex.Append(dir, RecordEntryST{
+		Time:     start,
+		Duration: element.Duration.Milliseconds(),
+		Init:     fmt.Sprintf("%08x", crc),
+		Size:     size,
+		Codecs:   element.Codecs,
+		Width:    element.Width,
+		Height:   element.Height,
+	})
+}
+
+//writeFileAtomic temp file in same dir, sync and rename, crash leave old or new never partial
+func writeFileAtomic(name string, data [][]byte) error {
+	dir := filepath.Dir(name)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	for _, buf := range data {
+		if _, err = tmp.Write(buf); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	//rename durable after dir sync
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+//parseRecordName segment file name start, duration and init crc32
+func parseRecordName(name string) (time.Time, time.Duration, string, bool) {
+	if !strings.HasSuffix(name, ".m4s") {
+		return time.Time{}, 0, "", false
+	}
+	parts := strings.Split(strings.TrimSuffix(name, ".m4s"), "_")
+	if len(parts) != 3 {
+		return time.Time{}, 0, "", false
+	}
+	start, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, "", false
+	}
+	duration, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, "", false
+	}
+	return time.Unix(0, start*int64(time.Millisecond)), time.Duration(duration) * time.Millisecond, parts[2], true
+}
+
+//recordScan stream segments on disk oldest first, day dirs limit from to, zero no limit
+func recordScan(root string, uuid string, from time.Time, to time.Time) ([]RecordFileST, error) {
+	days, err := ioutil.ReadDir(filepath.Join(root, uuid))
+	if err != nil {
+		return nil, err
+	}
+	var res []RecordFileST
+	for _, day := range days {
+		if !day.IsDir() {
+			continue
+		}
+		//segment in dir of start day may cross midnight into from
+		if !from.IsZero() && day.Name() < from.Add(-24*time.Hour).UTC().Format(RecordDateLayout) {
+			continue
+		}
+		if !to.IsZero() && day.Name() > to.UTC().Format(RecordDateLayout) {
+			continue
+		}
+		dir := filepath.Join(root, uuid, day.Name())
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			start, duration, crc, ok := parseRecordName(file.Name())
+			if !ok {
+				continue
+			}
+			res = append(res, RecordFileST{
+				Path:     filepath.Join(dir, file.Name()),
+				Stream:   uuid,
+				Time:     start,
+				Duration: duration,
+				Init:     filepath.Join(dir, "init_"+crc+".mp4"),
+				Size:     file.Size(),
+			})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Time.Before(res[j].Time)
+	})
+	return res, nil
+}
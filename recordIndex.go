@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const RecordIndexFile = "index.jsonl" //one json line per segment in day dir
+
+//recordIndex day index lock and parsed cache
+var recordIndex = &RecordIndexST{cache: make(map[string]recordIndexCache)}
+
+//RecordIndexST serialize index append, rewrite and read
+type RecordIndexST struct {
+	mutex sync.Mutex
+	cache map[string]recordIndexCache
+}
+
+//recordIndexCache parsed index with file state
+type recordIndexCache struct {
+	modTime time.Time
+	size    int64
+	entries []RecordEntryST
+}
+
+//RecordEntryST index line recorded segment and media
+type RecordEntryST struct {
+	Time     int64  `json:"time"`     //Start unix ms
+	Duration int64  `json:"duration"` //Duration ms
+	Init     string `json:"init"`     //Init crc32
+	Size     int64  `json:"size"`
+	Codecs   string `json:"codecs,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+}
+
+//Start segment wall clock start
+func (element RecordEntryST) Start() time.Time {
+	return time.Unix(0, element.Time*int64(time.Millisecond))
+}
+
+//End segment wall clock end
+func (element RecordEntryST) End() time.Time {
+	return time.Unix(0, (element.Time+element.Duration)*int64(time.Millisecond))
+}
+
+//Append add entry after segment file renamed, crash may leave partial last line
+func (element *RecordIndexST) Append(dir string, entry RecordEntryST) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	//day recorded before index, build first to keep older segments
+	if _, err = os.Stat(filepath.Join(dir, RecordIndexFile)); os.IsNotExist(err) {
+		entries, err := recordIndexBuild(dir)
+		if err != nil {
+			return err
+		}
+		if err = element.write(dir, entries); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(filepath.Join(dir, RecordIndexFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+//Read day index, cached until file change, day recorded before index built once from dir
+func (element *RecordIndexST) Read(dir string) ([]RecordEntryST, error) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	name := filepath.Join(dir, RecordIndexFile)
+	info, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		if _, err = os.Stat(dir); err != nil {
+			return nil, err
+		}
+		entries, err := recordIndexBuild(dir)
+		if err != nil {
+			return nil, err
+		}
+		if err = element.write(dir, entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if tmp, ok := element.cache[name]; ok && tmp.modTime.Equal(info.ModTime()) && tmp.size == info.Size() {
+		return tmp.entries, nil
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	entries := recordIndexParse(data)
+	element.cache[name] = recordIndexCache{modTime: info.ModTime(), size: info.Size(), entries: entries}
+	return entries, nil
+}
+
+//Remove drop entries of removed segments, retention
+func (element *RecordIndexST) Remove(dir string, times map[int64]bool) error {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	name := filepath.Join(dir, RecordIndexFile)
+	delete(element.cache, name)
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var entries []RecordEntryST
+	for _, entry := range recordIndexParse(data) {
+		if !times[entry.Time] {
+			entries = append(entries, entry)
+		}
+	}
+	return element.write(dir, entries)
+}
+
+//Forget drop cache of removed day dir
+func (element *RecordIndexST) Forget(dir string) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	delete(element.cache, filepath.Join(dir, RecordIndexFile))
+}
+
+//write replace day index atomic, call under lock
+func (element *RecordIndexST) write(dir string, entries []RecordEntryST) error {
+	var data [][]byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		data = append(data, append(line, '\n'))
+	}
+	return writeFileAtomic(filepath.Join(dir, RecordIndexFile), data)
+}
+
+//recordIndexParse index lines by start, duplicate start keep last line
+func recordIndexParse(data []byte) []RecordEntryST {
+	var entries []RecordEntryST
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var entry RecordEntryST
+		//partial line after crash skip
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time < entries[j].Time
+	})
+	//index built from dir while recorder append same segment with media
+	var n int
+	for i, entry := range entries {
+		if n > 0 && entries[n-1].Time == entry.Time {
+			n--
+		}
+		entries[n] = entries[i]
+		n++
+	}
+	return entries[:n]
+}
+
+//recordIndexBuild index from segment file names, media info unknown
+func recordIndexBuild(dir string) ([]RecordEntryST, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []RecordEntryST
+	for _, file := range files {
+		start, duration, crc, ok := parseRecordName(file.Name())
+		if !ok || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		res = append(res, RecordEntryST{
+			Time:     start.UnixNano() / int64(time.Millisecond),
+			Duration: duration.Milliseconds(),
+			Init:     crc,
+			Size:     file.Size(),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Time < res[j].Time
+	})
+	return res, nil
+}
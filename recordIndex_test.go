@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRecordIndexParse(t *testing.T) {
+	if res := recordIndexParse(nil); len(res) != 0 {
+		t.Errorf("empty %+v", res)
+	}
+	//sorted by start, duplicate start keep last, partial last line skipped
+	data := `{"time":3000,"duration":2000,"init":"b","size":3}` + "\n" +
+		`{"time":1000,"duration":2000,"init":"a","size":1}` + "\n" +
+		`{"time":1000,"duration":2000,"init":"a","size":1,"codecs":"avc1.64001f","width":1280,"height":720}` + "\n" +
+		`{"time":5000,"dura`
+	want := []RecordEntryST{
+		{Time: 1000, Duration: 2000, Init: "a", Size: 1, Codecs: "avc1.64001f", Width: 1280, Height: 720},
+		{Time: 3000, Duration: 2000, Init: "b", Size: 3},
+	}
+	if res := recordIndexParse([]byte(data)); !reflect.DeepEqual(res, want) {
+		t.Errorf("parse %+v", res)
+	}
+}
+
+func TestRecordIndexBuild(t *testing.T) {
+	dir := t.TempDir()
+	for name, size := range map[string]int{
+		"1792300002000_2000_04c0925e.m4s":  20,
+		"1792300000000_2000_04c0925e.m4s":  10,
+		"init_04c0925e.mp4":                5,
+		".1792300004000_2000_04c0925e.m4s": 7,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []RecordEntryST{
+		{Time: 1792300000000, Duration: 2000, Init: "04c0925e", Size: 10},
+		{Time: 1792300002000, Duration: 2000, Init: "04c0925e", Size: 20},
+	}
+	if res, err := recordIndexBuild(dir); err != nil || !reflect.DeepEqual(res, want) {
+		t.Errorf("build %+v %v", res, err)
+	}
+	//day recorded before index, first read build and write index
+	index := &RecordIndexST{cache: make(map[string]recordIndexCache)}
+	if res, err := index.Read(dir); err != nil || !reflect.DeepEqual(res, want) {
+		t.Errorf("read %+v %v", res, err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, RecordIndexFile)); err != nil || !reflect.DeepEqual(recordIndexParse(data), want) {
+		t.Errorf("index file %s %v", data, err)
+	}
+}
+
+func TestRecordIndexAppendRemove(t *testing.T) {
+	dir := t.TempDir()
+	index := &RecordIndexST{cache: make(map[string]recordIndexCache)}
+	for _, entry := range []RecordEntryST{{Time: 1000, Duration: 2000, Init: "a", Size: 1}, {Time: 3000, Duration: 2000, Init: "a", Size: 2}, {Time: 5000, Duration: 2000, Init: "a", Size: 3}} {
+		if err := index.Append(dir, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if res, err := index.Read(dir); err != nil || len(res) != 3 || res[2].Size != 3 {
+		t.Errorf("append %+v %v", res, err)
+	}
+	if err := index.Remove(dir, map[int64]bool{1000: true, 5000: true}); err != nil {
+		t.Fatal(err)
+	}
+	//new instance read from file not cache
+	index = &RecordIndexST{cache: make(map[string]recordIndexCache)}
+	if res, err := index.Read(dir); err != nil || len(res) != 1 || res[0].Time != 3000 {
+		t.Errorf("remove %+v %v", res, err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRecordName(t *testing.T) {
+	start, duration, crc, ok := parseRecordName("1792300000123_2040_04c0925e.m4s")
+	if !ok || start.UnixNano() != 1792300000123*int64(time.Millisecond) || duration != 2040*time.Millisecond || crc != "04c0925e" {
+		t.Errorf("parse %v %v %s %v", start, duration, crc, ok)
+	}
+	for _, name := range []string{"init_04c0925e.mp4", "1792300000123_2040.m4s", "x_2040_04c0925e.m4s", "1792300000123_x_04c0925e.m4s", "1792300000123_2040_04c0925e.m4s.tmp", RecordIndexFile} {
+		if _, _, _, ok := parseRecordName(name); ok {
+			t.Errorf("%s parsed", name)
+		}
+	}
+}
+
+func TestRecordSegmentWrite(t *testing.T) {
+	root := t.TempDir()
+	//local time zone, day dir by UTC date
+	start := time.Date(2026, 10, 18, 23, 59, 58, 0, time.UTC).In(time.FixedZone("east", 3*3600))
+	for i := 0; i < 2; i++ {
+		segment := RecordSegmentST{Path: root, UUID: "cam1", Time: start.Add(time.Duration(i) * 2 * time.Second), Duration: 2 * time.Second, Init: []byte("init"), Data: [][]byte{[]byte("part1"), []byte("part2")}}
+		if err := segment.write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//init once per day dir, segment on next day get own init
+	for name, data := range map[string]string{
+		"2026-10-18/init_c674e474.mp4":               "init",
+		"2026-10-18/1792367998000_2000_c674e474.m4s": "part1part2",
+		"2026-10-19/init_c674e474.mp4":               "init",
+		"2026-10-19/1792368000000_2000_c674e474.m4s": "part1part2",
+	} {
+		buf, err := ioutil.ReadFile(filepath.Join(root, "cam1", name))
+		if err != nil || string(buf) != data {
+			t.Errorf("%s %q %v", name, buf, err)
+		}
+	}
+	//existing init not rewritten for next segment same day
+	initName := filepath.Join(root, "cam1", "2026-10-19", "init_c674e474.mp4")
+	if err := ioutil.WriteFile(initName, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	segment := RecordSegmentST{Path: root, UUID: "cam1", Time: start.Add(4 * time.Second), Duration: 2 * time.Second, Init: []byte("init"), Data: [][]byte{[]byte("part")}}
+	if err := segment.write(); err != nil {
+		t.Fatal(err)
+	}
+	if buf, _ := ioutil.ReadFile(initName); string(buf) != "keep" {
+		t.Errorf("init rewritten %q", buf)
+	}
+	//no temp file left
+	for _, day := range []string{"2026-10-18", "2026-10-19"} {
+		files, _ := ioutil.ReadDir(filepath.Join(root, "cam1", day))
+		for _, file := range files {
+			if strings.HasPrefix(file.Name(), ".") {
+				t.Errorf("temp file %s", file.Name())
+			}
+		}
+	}
+}
+
+func TestRecordScan(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"2026-10-19/1792368002000_2000_04c0925e.m4s",
+		"2026-10-19/1792368000000_2000_04c0925e.m4s",
+		"2026-10-18/1792367998000_2000_04c0925e.m4s",
+		"2026-10-18/init_04c0925e.mp4",
+		"2026-10-16/1792195200000_2000_04c0925e.m4s",
+		"2026-10-18/.1792367996000_2000_04c0925e.m4s.tmp",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, "cam1", filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, "cam1", name), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := recordScan(root, "cam1", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//oldest first over days, init and temp skipped
+	var got []string
+	for _, file := range files {
+		got = append(got, filepath.Base(file.Path))
+	}
+	if strings.Join(got, " ") != "1792195200000_2000_04c0925e.m4s 1792367998000_2000_04c0925e.m4s 1792368000000_2000_04c0925e.m4s 1792368002000_2000_04c0925e.m4s" {
+		t.Errorf("scan %v", got)
+	}
+	if files[1].Init != filepath.Join(root, "cam1", "2026-10-18", "init_04c0925e.mp4") || files[1].Size != 4 || files[1].Duration != 2*time.Second {
+		t.Errorf("file %+v", files[1])
+	}
+	//day dirs from day before from to day of to
+	files, err = recordScan(root, "cam1", time.Date(2026, 10, 19, 0, 0, 1, 0, time.UTC), time.Date(2026, 10, 19, 0, 0, 1, 0, time.UTC))
+	if err != nil || len(files) != 3 {
+		t.Errorf("range %d %v", len(files), err)
+	}
+	if _, err = recordScan(root, "missing", time.Time{}, time.Time{}); err == nil {
+		t.Error("missing stream no error")
+	}
+}
+
+func TestRecorderFlush(t *testing.T) {
+	root := t.TempDir()
+	Recorder.Write(RecordSegmentST{Path: root, UUID: "cam1", Time: time.Unix(1792368000, 0), Duration: time.Second, Init: []byte("init"), Data: [][]byte{[]byte("data")}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := Recorder.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "cam1", "2026-10-19", "1792368000000_1000_c674e474.m4s")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRecorderQueueFull(t *testing.T) {
+	//no writer running, queue full drop without block
+	recorder := &RecorderST{queue: make(chan RecordSegmentST, 1)}
+	done := make(chan struct{})
+	go func() {
+		recorder.Write(RecordSegmentST{UUID: "cam1"})
+		recorder.Write(RecordSegmentST{UUID: "cam1"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("write blocked")
+	}
+	if len(recorder.queue) != 1 {
+		t.Errorf("queue %d", len(recorder.queue))
+	}
+}
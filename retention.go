@@ -0,0 +1,201 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	RetentionInterval = time.Minute //cleanup pass period
+	RetentionTmpAge   = time.Hour   //orphan temp file from crash
+)
+
+const (
+	RetentionReasonAge  = "age"
+	RetentionReasonSize = "size"
+	RetentionReasonDisk = "disk"
+)
+
+//RetentionST stream footage policy 0 keep
+type RetentionST struct {
+	Age  time.Duration //Max footage age
+	Size int64         //Max stream footage bytes
+}
+
+//recordPolicies stream dirs with policy, roots to scan, policy for dirs of deleted streams and disk ceiling
+func (element *ConfigST) recordPolicies() (map[string]RetentionST, map[string]bool, RetentionST, int64) {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	policies := make(map[string]RetentionST)
+	roots := map[string]bool{serverRecordRoot(element.Server.RecordPath): true}
+	for uuid, tmp := range element.Streams {
+		root := tmp.recordRoot(element.Server.RecordPath)
+		roots[root] = true
+		policies[filepath.Join(root, uuid)] = tmp.retention(element.Server)
+	}
+	fallback := RetentionST{Age: time.Duration(element.Server.RecordRetentionDays) * 24 * time.Hour}
+	return policies, roots, fallback, int64(element.Server.RecordMaxDiskMB) << 20
+}
+
+//retention cleanup loop
+func (element *ConfigST) retention() {
+	for {
+		element.RetentionPass()
+		time.Sleep(RetentionInterval)
+	}
+}
+
+//RetentionPass evict oldest first by age and stream size, then by disk ceiling over all streams
+func (element *ConfigST) RetentionPass() {
+	policies, roots, fallback, ceiling := element.recordPolicies()
+	var all []RecordFileST
+	var dirs []string
+	for root := range roots {
+		streams, err := ioutil.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, stream := range streams {
+			if !stream.IsDir() {
+				continue
+			}
+			dir := filepath.Join(root, stream.Name())
+			dirs = append(dirs, dir)
+			files, err := recordScan(root, stream.Name(), time.Time{}, time.Time{})
+			if err != nil {
+				log.Println(stream.Name(), "Record Retention Scan Error", err)
+				continue
+			}
+			policy, ok := policies[dir]
+			if !ok {
+				policy = fallback
+			}
+			all = append(all, retentionStream(files, policy)...)
+		}
+	}
+	if ceiling > 0 {
+		sort.Slice(all, func(i, j int) bool {
+			return all[i].Time.Before(all[j].Time)
+		})
+		var size int64
+		for _, file := range all {
+			size += file.Size
+		}
+		var n int
+		for ; n < len(all) && size > ceiling; n++ {
+			size -= all[n].Size
+		}
+		retentionRemove(all[:n], RetentionReasonDisk)
+		all = all[n:]
+	}
+	//footage of deleted streams count for ceiling only
+	_, streams := element.list()
+	usage := make(map[string]int64)
+	for _, uuid := range streams {
+		usage[uuid] = 0
+	}
+	for _, file := range all {
+		if _, ok := usage[file.Stream]; ok {
+			usage[file.Stream] += file.Size
+		}
+	}
+	for uuid, size := range usage {
+		Metrics.RecordBytes(uuid, size)
+	}
+	for _, dir := range dirs {
+		retentionCleanDays(dir)
+	}
+}
+
+//retentionStream remove by age then oldest over size, return kept
+func retentionStream(files []RecordFileST, policy RetentionST) []RecordFileST {
+	var n int
+	if policy.Age > 0 {
+		for n < len(files) && time.Since(files[n].Time.Add(files[n].Duration)) > policy.Age {
+			n++
+		}
+		retentionRemove(files[:n], RetentionReasonAge)
+		files = files[n:]
+	}
+	if policy.Size > 0 {
+		var size int64
+		for _, file := range files {
+			size += file.Size
+		}
+		for n = 0; n < len(files) && size > policy.Size; n++ {
+			size -= files[n].Size
+		}
+		retentionRemove(files[:n], RetentionReasonSize)
+		files = files[n:]
+	}
+	return files
+}
+
+//retentionRemove delete segments and index lines, log and count per stream
+func retentionRemove(files []RecordFileST, reason string) {
+	count := make(map[string]int)
+	size := make(map[string]int64)
+	removed := make(map[string]map[int64]bool)
+	for _, file := range files {
+		if err := os.Remove(file.Path); err != nil {
+			log.Println(file.Stream, "Record Retention Remove Error", err)
+			continue
+		}
+		dir := filepath.Dir(file.Path)
+		if removed[dir] == nil {
+			removed[dir] = make(map[int64]bool)
+		}
+		removed[dir][file.Time.UnixNano()/int64(time.Millisecond)] = true
+		count[file.Stream]++
+		size[file.Stream] += file.Size
+		Metrics.Retention(reason, file.Size)
+	}
+	for dir, times := range removed {
+		if err := recordIndex.Remove(dir, times); err != nil {
+			log.Println("Record Retention Index Error", err)
+		}
+	}
+	for uuid := range count {
+		log.Println(uuid, "Record Retention", reason, "Removed Segments", count[uuid], "Bytes", size[uuid])
+	}
+}
+
+//retentionCleanDays remove past day dirs without segments and old temp files
+func retentionCleanDays(dir string) {
+	days, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	//recorder may create today dir any time
+	today := time.Now().UTC().Format(RecordDateLayout)
+	for _, day := range days {
+		if !day.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, day.Name())
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			continue
+		}
+		var segments int
+		for _, file := range files {
+			switch {
+			case strings.HasSuffix(file.Name(), ".m4s"):
+				segments++
+			case strings.HasPrefix(file.Name(), ".") && time.Since(file.ModTime()) > RetentionTmpAge:
+				os.Remove(filepath.Join(path, file.Name()))
+			}
+		}
+		if segments == 0 && day.Name() < today {
+			if err = os.RemoveAll(path); err != nil {
+				log.Println("Record Retention Remove Day Error", err)
+			}
+			recordIndex.Forget(path)
+		}
+	}
+}
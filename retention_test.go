@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//testRecordFile sparse segment of size in stream day dir
+func testRecordFile(t *testing.T, root string, uuid string, start time.Time, size int64) string {
+	t.Helper()
+	dir := filepath.Join(root, uuid, start.UTC().Format(RecordDateLayout))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%d_1000_04c0925e.m4s", start.UnixNano()/int64(time.Millisecond)))
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err = file.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+//testExists exists flag per file
+func testExists(names ...string) []bool {
+	var res []bool
+	for _, name := range names {
+		_, err := os.Stat(name)
+		res = append(res, err == nil)
+	}
+	return res
+}
+
+func TestRetentionStream(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	names := []string{
+		testRecordFile(t, root, "cam1", now.Add(-72*time.Hour), 100),
+		testRecordFile(t, root, "cam1", now.Add(-30*time.Hour), 100),
+		testRecordFile(t, root, "cam1", now.Add(-3*time.Hour), 100),
+		testRecordFile(t, root, "cam1", now.Add(-2*time.Hour), 100),
+		testRecordFile(t, root, "cam1", now.Add(-time.Hour), 100),
+	}
+	files, err := recordScan(root, "cam1", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//age first, then oldest until under size
+	kept := retentionStream(files, RetentionST{Age: 24 * time.Hour, Size: 250})
+	if len(kept) != 2 || kept[0].Path != names[3] {
+		t.Errorf("kept %+v", kept)
+	}
+	if res := fmt.Sprint(testExists(names...)); res != "[false false false true true]" {
+		t.Errorf("exists %s", res)
+	}
+	//zero policy keep all
+	if kept = retentionStream(kept, RetentionST{}); len(kept) != 2 {
+		t.Errorf("zero policy kept %d", len(kept))
+	}
+}
+
+func TestRetentionPass(t *testing.T) {
+	root := t.TempDir()
+	element := &ConfigST{
+		Server: ServerST{RecordPath: root, RecordRetentionDays: 1, RecordMaxDiskMB: 1},
+		Streams: map[string]StreamST{
+			"test-retention-a": {Record: true, RecordRetentionDays: 7},
+			"test-retention-b": {Record: true, RecordRetentionDays: 7},
+		},
+	}
+	now := time.Now()
+	const size = 400 << 10
+	names := []string{
+		testRecordFile(t, root, "test-retention-a", now.Add(-4*time.Hour), size),
+		testRecordFile(t, root, "test-retention-b", now.Add(-3*time.Hour), size),
+		testRecordFile(t, root, "test-retention-a", now.Add(-2*time.Hour), size),
+		testRecordFile(t, root, "test-retention-b", now.Add(-time.Hour), size),
+		//deleted stream use server age
+		testRecordFile(t, root, "test-retention-gone", now.Add(-48*time.Hour), 1),
+	}
+	element.RetentionPass()
+	//ceiling evict oldest over all streams
+	if res := fmt.Sprint(testExists(names...)); res != "[false false true true false]" {
+		t.Errorf("exists %s", res)
+	}
+	//emptied past day dir removed
+	if _, err := os.Stat(filepath.Dir(names[4])); !os.IsNotExist(err) {
+		t.Errorf("day dir %v", err)
+	}
+}
@@ -1,52 +1,43 @@
 package main
 
 import (
+	"context"
 	"log"
 	"math"
 	"time"
 
+	"github.com/deepch/vdk/av"
 	"github.com/deepch/vdk/format/rtspv2"
 )
 
 //serveStreams main start
 func serveStreams() {
-	for k, v := range Config.Streams {
-		if v.OnDemand {
-			log.Println(k, "OnDemand not supported")
-			v.OnDemand = false
-		}
-		if !v.OnDemand {
-			go RTSPWorkerLoop(k, v.URL)
-		}
-	}
-}
-
-//RTSPWorkerLoop work loop
-func RTSPWorkerLoop(name, url string) {
-	defer Config.RunUnlock(name)
-	for {
-		log.Println(name, "Stream Try Connect")
-		err := RTSPWorker(name, url)
-		if err != nil {
-			log.Println(name, err)
-		}
-		//reconnect delay
-		time.Sleep(1 * time.Second)
+	_, all := Config.list()
+	for _, k := range all {
+		//on demand start on first playlist or init request
+		Config.StreamRun(k)
 	}
 }
 
-func RTSPWorker(name, url string) error {
+//RTSPWorker one source session until error, context cancel or reconnect
+func RTSPWorker(ctx context.Context, supervisor *Supervisor, url string) error {
+	name := supervisor.UUID
 	FPSMode := Config.FPSMode(name)
-	var start bool
+	var start, online bool
 	var fps int
 	keyTest := time.NewTimer(20 * time.Second)
+	viewerTest := time.NewTicker(1 * time.Second)
+	defer viewerTest.Stop()
 	/*
 		FPS mode fixed
 	*/
 	if FPSMode == FPSModeFixed {
 		fps = 24
 	}
-	RTSPClient, err := rtspv2.Dial(rtspv2.RTSPClientOptions{URL: url, DisableAudio: true, DialTimeout: 3 * time.Second, ReadWriteTimeout: 3 * time.Second, Debug: false})
+	RTSPClient, err := rtspv2.Dial(rtspv2.RTSPClientOptions{URL: url, DisableAudio: !Config.Audio(name), OutgoingProxy: true, DialTimeout: 3 * time.Second, ReadWriteTimeout: 3 * time.Second, Debug: false})
+	if err != nil {
+		return err
+	}
 	/*
 		FPS mode sdp
 	*/
@@ -54,75 +45,220 @@ func RTSPWorker(name, url string) error {
 		log.Println("fps sdp update new", fps)
 		fps = RTSPClient.FPS
 	}
-	if err != nil {
-		return err
-	}
 	defer RTSPClient.Close()
-	if RTSPClient.CodecData != nil {
-		Config.coAd(name, RTSPClient.CodecData)
+	codecs, codecIdx := filterCodecs(name, RTSPClient.CodecData)
+	//muxer survive reconnect keep MSN and window, init ready before codecs published
+	Config.NewHLSMuxer(name)
+	Config.HlsMuxerSetCodecs(name, codecs)
+	defer Config.HLSMuxerDiscontinuity(name)
+	if codecs != nil {
+		Config.coAd(name, codecs)
 	}
 	/*
 		FPS mode sps
 	*/
 	if FPSMode == FPSModeSPS {
-		fps = updateGetFPS(fps, RTSPClient.CodecData)
+		fps = updateGetFPS(fps, codecs)
 	}
 	var AudioOnly bool
-	if len(RTSPClient.CodecData) == 1 && RTSPClient.CodecData[0].Type().IsAudio() {
+	if len(codecs) == 1 && codecs[0].Type().IsAudio() {
 		AudioOnly = true
 	}
+	//PROGRAM-DATE-TIME from master track arrival or video RTCP sender report
+	masterIdx := masterTrack(codecs)
+	wallClock := NewWallClock(90000)
+	//audio client time synthetic can not map to RTP, sender report of video only
+	rtcpChannel := -1
+	videoChannel, audioChannel := rtpChannels(RTSPClient.SDPRaw, !Config.Audio(name))
+	if !AudioOnly && videoChannel >= 0 {
+		rtcpChannel = videoChannel + 1
+	}
+	var audioRate int64
+	for _, codec := range codecs {
+		if codec.Type() == av.AAC {
+			audioRate = TimeScale(codec)
+		}
+	}
+	if audioRate == 0 {
+		audioChannel = -1
+	}
+	//raw queue fill per RTP packet, drain separate so worker stall never drop source
+	proxyCtx, proxyCancel := context.WithCancel(ctx)
+	defer proxyCancel()
+	proxy := NewRTPProxy(rtcpChannel, audioChannel, audioRate)
+	go proxy.Run(proxyCtx, RTSPClient.OutgoingProxyQueue)
 	var ProbeCount int
 	var ProbeFrame int
 	var ProbePTS time.Duration
-	Config.NewHLSMuxer(name)
-	defer Config.HLSMuxerClose(name)
+	//track time lines start at stream start for A/V sync
+	var startTime time.Time
+	timeLines := make(map[int8]*TimeLine)
+	//last frame of each track wait next for duration, write before discontinuity
+	defer func() {
+		if !online {
+			return
+		}
+		for _, timeLine := range timeLines {
+			if packet := timeLine.Flush(); packet != nil {
+				Config.HlsMuxerWritePacket(name, packet, wallClock.Wall(packet.Time))
+			}
+		}
+	}()
 	for {
 		select {
 		case <-keyTest.C:
+			supervisor.Event(WebhookEventST{Event: WebhookEventNoVideo, Reason: ErrorStreamExitNoVideoOnStream.Error()})
 			return ErrorStreamExitNoVideoOnStream
+		case <-viewerTest.C:
+			if !Config.HasViewer(name) {
+				return ErrorStreamExitNoViewer
+			}
+		case <-ctx.Done():
+			return ErrorStreamStopCoreSignal
+		case <-supervisor.reconnect:
+			return ErrorStreamReconnect
 		case signals := <-RTSPClient.Signals:
 			switch signals {
 			case rtspv2.SignalCodecUpdate:
-				Config.coAd(name, RTSPClient.CodecData)
+				codecs, codecIdx = filterCodecs(name, RTSPClient.CodecData)
+				masterIdx = masterTrack(codecs)
+				Config.HlsMuxerSetCodecs(name, codecs)
+				Config.coAd(name, codecs)
+				event := WebhookEventST{Event: WebhookEventCodecChange}
+				for _, codec := range codecs {
+					event.Codecs = append(event.Codecs, codecStatus(codec))
+				}
+				supervisor.Event(event)
 				/*
 					FPS mode sps
 				*/
 				if FPSMode == FPSModeSPS {
-					fps = updateGetFPS(fps, RTSPClient.CodecData)
+					fps = updateGetFPS(fps, codecs)
 				}
 			case rtspv2.SignalStreamRTPStop:
 				return ErrorStreamExitRtspDisconnect
 			}
+		case report := <-proxy.Reports:
+			wallClock.SenderReport(report.NTP, report.RTP)
 		case packetAV := <-RTSPClient.OutgoingPacketQueue:
+			idx, ok := codecIdx[packetAV.Idx]
+			if !ok {
+				continue
+			}
+			packetAV.Idx = idx
+			Metrics.Packet(name, len(packetAV.Data))
+			isVideo := codecs[idx].Type().IsVideo()
+			if codecs[idx].Type() == av.H265 {
+				//client mark only IDR_W_RADL accept all IRAP
+				packetAV.IsKeyFrame = hevcIsKeyFrame(packetAV.Data)
+			}
+			if !isVideo {
+				//all audio frames sync sample
+				packetAV.IsKeyFrame = AudioOnly
+			}
 			//wait fist key on start
-			if packetAV.IsKeyFrame && !start {
+			if (packetAV.IsKeyFrame || AudioOnly) && !start {
 				start = true
+				startTime = time.Now()
 			}
-			/*
-				FPS mode probe
-			*/
-			if start && FPSMode == FPSModeProbe {
-				ProbePTS += packetAV.Duration
-				ProbeFrame++
-				if packetAV.IsKeyFrame && ProbePTS.Seconds() >= 1 {
-					ProbeCount++
-					if ProbeCount == 2 {
-						fps = int(math.Round(float64(ProbeFrame) / ProbePTS.Seconds()))
-					}
-					ProbeFrame = 0
-					ProbePTS = 0
-				}
+			if !start {
+				continue
 			}
 			if AudioOnly || packetAV.IsKeyFrame {
 				keyTest.Reset(20 * time.Second)
 			}
-			if (start && FPSMode != FPSModeProbe && fps != 0) || (ProbeCount > 2) || (start && (FPSMode == FPSModePTS || FPSMode == FPSModeFixed)) {
-				if FPSMode != FPSModePTS {
-					//TODO fix it
-					packetAV.Duration = time.Duration((float32(1000)/float32(fps))*1000*1000) * time.Nanosecond
+			timeLine, ok := timeLines[idx]
+			if !ok {
+				timeLine = NewTimeLine(time.Since(startTime), TimeLineWrapRTP90K, fpsDuration(fps))
+				if isVideo {
+					timeLine.Merge = true
+				} else {
+					//AAC frame 1024 samples client round it to ms
+					timeLine.Wrap = 0
+					timeLine.Fixed = true
+					timeLine.Fallback = 1024 * time.Second / time.Duration(TimeScale(codecs[idx]))
+					//loss before track start not in time line
+					proxy.AudioLost()
+				}
+				timeLines[idx] = timeLine
+			}
+			if !isVideo {
+				//lost RTP packets missing from frame count, skip keep A/V sync
+				if lost := proxy.AudioLost(); lost > 0 {
+					timeLine.Skip(lost)
+					log.Println(name, "track", idx, "audio lost", lost)
 				}
-				Config.HlsMuxerSetFPS(name, fps)
-				Config.HlsMuxerWritePacket(name, packetAV)
+			}
+			if isVideo && fps != 0 {
+				//FPS modes fallback only
+				timeLine.SetFallback(fpsDuration(fps))
+			}
+			discontinuities := timeLine.Discontinuities
+			raw := packetAV.Time
+			packets := timeLine.Push(packetAV)
+			if timeLine.Discontinuities != discontinuities {
+				log.Println(name, "track", idx, "timestamp discontinuity")
+				if idx == masterIdx {
+					wallClock.Reset()
+				}
+			}
+			if idx == masterIdx {
+				wallClock.Update(raw, packetAV.Time, time.Now())
+			}
+			for _, packet := range packets {
+				/*
+					FPS mode probe
+				*/
+				if isVideo && FPSMode == FPSModeProbe {
+					ProbePTS += packet.Duration
+					ProbeFrame++
+					if packet.IsKeyFrame && ProbePTS.Seconds() >= 1 {
+						ProbeCount++
+						if ProbeCount == 2 {
+							fps = int(math.Round(float64(ProbeFrame) / ProbePTS.Seconds()))
+						}
+						ProbeFrame = 0
+						ProbePTS = 0
+					}
+				}
+				if AudioOnly || (FPSMode != FPSModeProbe && fps != 0) || (ProbeCount > 2) || (FPSMode == FPSModePTS || FPSMode == FPSModeFixed) {
+					Config.HlsMuxerSetFPS(name, fps)
+					Config.HlsMuxerWritePacket(name, packet, wallClock.Wall(packet.Time))
+					if !online {
+						online = true
+						supervisor.SetState(StreamStateOnline)
+					}
+				}
+			}
+		}
+	}
+}
+
+//RTSPProbe dial source and wait first video key frame, failback primary health check
+func RTSPProbe(ctx context.Context, url string) error {
+	RTSPClient, err := rtspv2.Dial(rtspv2.RTSPClientOptions{URL: url, DisableAudio: true, DialTimeout: 3 * time.Second, ReadWriteTimeout: 3 * time.Second, Debug: false})
+	if err != nil {
+		return err
+	}
+	defer RTSPClient.Close()
+	keyTest := time.NewTimer(20 * time.Second)
+	defer keyTest.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-keyTest.C:
+			return ErrorStreamExitNoVideoOnStream
+		case signals := <-RTSPClient.Signals:
+			if signals == rtspv2.SignalStreamRTPStop {
+				return ErrorStreamExitRtspDisconnect
+			}
+		case packetAV := <-RTSPClient.OutgoingPacketQueue:
+			if int(packetAV.Idx) >= len(RTSPClient.CodecData) || !RTSPClient.CodecData[packetAV.Idx].Type().IsVideo() {
+				continue
+			}
+			if packetAV.IsKeyFrame || (RTSPClient.CodecData[packetAV.Idx].Type() == av.H265 && hevcIsKeyFrame(packetAV.Data)) {
+				return nil
 			}
 		}
 	}
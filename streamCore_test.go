@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//testRTSP fake camera, h264 25 fps key every second over interleaved tcp
+type testRTSP struct {
+	URL      string
+	down     int32 //1 close new connections without answer
+	sessions int32 //PLAY count
+	teardown int32 //TEARDOWN count
+}
+
+//testRTSPServer start fake camera closed on cleanup
+func testRTSPServer(t *testing.T) *testRTSP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &testRTSP{URL: "rtsp://" + listener.Addr().String() + "/test"}
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if atomic.LoadInt32(&server.down) == 1 {
+				conn.Close()
+				continue
+			}
+			go server.serve(conn, done)
+		}
+	}()
+	return server
+}
+
+//serve answer requests, after PLAY send frames until connection or server closed
+func (element *testRTSP) serve(conn net.Conn, done chan struct{}) {
+	defer conn.Close()
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	sdp := "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=test\r\nt=0 0\r\nm=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\n" +
+		"a=fmtp:96 packetization-mode=1;sprop-parameter-sets=" + base64.StdEncoding.EncodeToString(testSPS) + "," + base64.StdEncoding.EncodeToString(testPPS) + "\r\na=control:trackID=0\r\n"
+	for {
+		line, err := reader.ReadLine()
+		if err != nil {
+			return
+		}
+		header, err := reader.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		res := "RTSP/1.0 200 OK\r\nCSeq: " + header.Get("CSeq") + "\r\n"
+		switch strings.Fields(line)[0] {
+		case "DESCRIBE":
+			res += "Content-Base: " + element.URL + "/\r\nContent-Type: application/sdp\r\nContent-Length: " + fmt.Sprint(len(sdp)) + "\r\n\r\n" + sdp
+		case "SETUP":
+			res += "Session: 1;timeout=60\r\nTransport: " + header.Get("Transport") + "\r\n\r\n"
+		case "TEARDOWN":
+			atomic.AddInt32(&element.teardown, 1)
+			return
+		default:
+			res += "\r\n"
+		}
+		if _, err = conn.Write([]byte(res)); err != nil {
+			return
+		}
+		if strings.HasPrefix(line, "PLAY") {
+			atomic.AddInt32(&element.sessions, 1)
+			go element.play(conn, done)
+		}
+	}
+}
+
+//play one single nal rtp packet per frame on channel 0
+func (element *testRTSP) play(conn net.Conn, done chan struct{}) {
+	ticker := time.NewTicker(40 * time.Millisecond)
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		nal := []byte{0x41, 0x9a, 1, 2, 3}
+		if i%25 == 0 {
+			nal = []byte{0x65, 0x88, 1, 2, 3}
+		}
+		buf := make([]byte, 16+len(nal))
+		buf[0] = '$'
+		binary.BigEndian.PutUint16(buf[2:], uint16(12+len(nal)))
+		buf[4], buf[5] = 0x80, 0xe0
+		binary.BigEndian.PutUint16(buf[6:], uint16(i+1))
+		binary.BigEndian.PutUint32(buf[8:], uint32(i*3600+3600))
+		binary.BigEndian.PutUint32(buf[12:], 1)
+		copy(buf[16:], nal)
+		if _, err := conn.Write(buf); err != nil {
+			return
+		}
+		select {
+		case <-done:
+			conn.Close()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func TestRTSPProbe(t *testing.T) {
+	camera := testRTSPServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := RTSPProbe(ctx, camera.URL); err != nil {
+		t.Fatal(err)
+	}
+	//probe session closed with TEARDOWN
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&camera.teardown) == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+	}
+	if atomic.LoadInt32(&camera.sessions) != 1 || atomic.LoadInt32(&camera.teardown) != 1 {
+		t.Errorf("sessions %d teardown %d", camera.sessions, camera.teardown)
+	}
+	atomic.StoreInt32(&camera.down, 1)
+	if err := RTSPProbe(ctx, camera.URL); err == nil {
+		t.Error("probe down camera no error")
+	}
+}
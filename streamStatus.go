@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
+)
+
+//StreamStatusST worker state and media info for api
+type StreamStatusST struct {
+	State        string          `json:"state"`
+	Since        time.Time       `json:"since"`
+	Connected    *time.Time      `json:"connected,omitempty"`
+	Source       int             `json:"source"`
+	LastError    string          `json:"last_error,omitempty"`
+	Codecs       []CodecStatusST `json:"codecs"`
+	FPS          int             `json:"fps"`
+	FPSMode      string          `json:"fps_mode"`
+	MSN          int             `json:"msn"`
+	Parts        int             `json:"parts"`
+	Bitrate      int             `json:"bitrate"`
+	LastKeyFrame *time.Time      `json:"last_key_frame,omitempty"`
+	GOPFrames    int             `json:"gop_frames"`
+	GOPMS        int64           `json:"gop_ms"`
+}
+
+//CodecStatusST track codec info, video from SPS
+type CodecStatusST struct {
+	Type       string `json:"type"`
+	Codec      string `json:"codec"`
+	Width      int    `json:"width,omitempty"`
+	Height     int    `json:"height,omitempty"`
+	Profile    string `json:"profile,omitempty"`
+	Level      string `json:"level,omitempty"`
+	SampleRate int    `json:"sample_rate,omitempty"`
+	Channels   int    `json:"channels,omitempty"`
+}
+
+//StreamStatus worker state, codecs and muxer counters
+func (element *ConfigST) StreamStatus(uuid string) (StreamStatusST, error) {
+	element.mutex.RLock()
+	tmp, ok := element.Streams[uuid]
+	element.mutex.RUnlock()
+	if !ok {
+		return StreamStatusST{}, ErrorStreamNotFound
+	}
+	state := tmp.Supervisor.Info()
+	res := StreamStatusST{
+		State:     state.State,
+		Since:     state.Since,
+		Connected: state.Connected,
+		Source:    state.Source,
+		Codecs:    []CodecStatusST{},
+		FPSMode:   tmp.FPSMode,
+		MSN:       -1,
+	}
+	if res.FPSMode == "" {
+		res.FPSMode = "probe"
+	}
+	if len(state.Failures) > 0 {
+		res.LastError = state.Failures[len(state.Failures)-1].Reason
+	}
+	//coGe wait codecs only worth it on online stream
+	if state.State == StreamStateOnline {
+		for _, codec := range element.coGe(uuid) {
+			res.Codecs = append(res.Codecs, codecStatus(codec))
+		}
+	}
+	if tmp.HlsMuxer != nil {
+		muxer := tmp.HlsMuxer.Status()
+		res.FPS = muxer.FPS
+		res.MSN = muxer.MSN
+		res.Parts = muxer.Parts
+		res.Bitrate = muxer.Bitrate
+		res.GOPFrames = muxer.GOPFrames
+		res.GOPMS = muxer.GOPDuration.Milliseconds()
+		if !muxer.KeyFrameTime.IsZero() {
+			res.LastKeyFrame = &muxer.KeyFrameTime
+		}
+	}
+	return res, nil
+}
+
+//codecStatus codec string, resolution profile and level from SPS
+func codecStatus(codec av.CodecData) CodecStatusST {
+	res := CodecStatusST{Type: codec.Type().String(), Codec: codecString([]av.CodecData{codec})}
+	switch codec.Type() {
+	case av.H264:
+		record := codec.(h264parser.CodecData).RecordInfo
+		res.Width, res.Height = videoSize(codec)
+		res.Profile = h264ProfileName(record.AVCProfileIndication)
+		res.Level = fmt.Sprintf("%d.%d", record.AVCLevelIndication/10, record.AVCLevelIndication%10)
+	case av.H265:
+		res.Width, res.Height = videoSize(codec)
+		video := codec.(h265parser.CodecData)
+		if len(video.RecordInfo.SPS) == 0 {
+			break
+		}
+		info, err := ParseHEVCSPS(video.RecordInfo.SPS[0])
+		if err != nil {
+			break
+		}
+		res.Profile = h265ProfileName(info.ProfileIDC)
+		//general_level_idc is 30 times level
+		res.Level = fmt.Sprintf("%d.%d", info.LevelIDC/30, info.LevelIDC%30/3)
+	case av.AAC:
+		audio := codec.(aacparser.CodecData)
+		res.SampleRate = audio.SampleRate()
+		res.Channels = audio.ChannelLayout().Count()
+	}
+	return res
+}
+
+//h264ProfileName profile_idc name
+func h264ProfileName(val uint8) string {
+	switch val {
+	case 66:
+		return "Baseline"
+	case 77:
+		return "Main"
+	case 88:
+		return "Extended"
+	case 100:
+		return "High"
+	case 110:
+		return "High 10"
+	case 122:
+		return "High 4:2:2"
+	case 244:
+		return "High 4:4:4"
+	}
+	return fmt.Sprintf("%d", val)
+}
+
+//h265ProfileName general_profile_idc name
+func h265ProfileName(val uint) string {
+	switch val {
+	case 1:
+		return "Main"
+	case 2:
+		return "Main 10"
+	case 3:
+		return "Main Still Picture"
+	case 4:
+		return "Range Extensions"
+	}
+	return fmt.Sprintf("%d", val)
+}
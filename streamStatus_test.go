@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/codec/aacparser"
+	"github.com/deepch/vdk/codec/h264parser"
+)
+
+func TestCodecStatus(t *testing.T) {
+	video, err := h264parser.NewCodecDataFromSPSAndPPS(testSPS, testPPS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := codecStatus(video); res != (CodecStatusST{Type: "H264", Codec: "avc1.64001f", Width: 1280, Height: 720, Profile: "High", Level: "3.1"}) {
+		t.Errorf("h264 %+v", res)
+	}
+	audio, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes([]byte{0x12, 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := codecStatus(audio); res != (CodecStatusST{Type: "AAC", Codec: "mp4a.40.2", SampleRate: 44100, Channels: 2}) {
+		t.Errorf("aac %+v", res)
+	}
+}
+
+func TestMuxerStatus(t *testing.T) {
+	muxer := testMuxer(t, 6)
+	wall := time.Now()
+	//key every 25 frames, 3 segments finished one open
+	testWriteVideo(muxer, 90, 100, wall)
+	status := muxer.Status()
+	if status.MSN != 3 || status.FPS != 25 || status.GOPFrames != 25 || status.GOPDuration != time.Second {
+		t.Errorf("status %+v", status)
+	}
+	if !status.KeyFrameTime.Equal(wall.Add(75 * 40 * time.Millisecond)) {
+		t.Errorf("key frame time %v", status.KeyFrameTime.Sub(wall))
+	}
+	//100 byte frames 25 fps 20 kbps payload, serialized adds box headers
+	if status.Bitrate < 20000 || status.Bitrate > 30000 || status.Parts < 15 {
+		t.Errorf("bitrate %d parts %d", status.Bitrate, status.Parts)
+	}
+}
+
+func TestStreamStatus(t *testing.T) {
+	camera := testRTSPServer(t)
+	supervisor := testStream(t, "test-status", StreamST{URL: "rtsp://127.0.0.1:1/test", URLs: []string{camera.URL}, FPSMode: "fixed"})
+	status, err := Config.StreamStatus("test-status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != StreamStateStopped || status.MSN != -1 || len(status.Codecs) != 0 || status.FPSMode != "fixed" {
+		t.Errorf("stopped %+v", status)
+	}
+	supervisor.Start()
+	testWaitState(t, supervisor, StreamStateOnline, 5*time.Second)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if status, _ = Config.StreamStatus("test-status"); status.LastKeyFrame != nil {
+			break
+		}
+	}
+	//failed primary in last error, backup source online
+	if status.Source != 1 || status.Connected == nil || status.LastError == "" || status.LastKeyFrame == nil {
+		t.Errorf("online %+v", status)
+	}
+	if len(status.Codecs) != 1 || status.Codecs[0].Width != 1280 || status.FPS == 0 || status.MSN != 0 {
+		t.Errorf("media %+v", status)
+	}
+	router := newRouter()
+	if code, msg := testAPI(t, router, http.MethodGet, "/api/streams/test-status/status", ""); code != http.StatusOK || msg.Payload.(map[string]interface{})["state"] != StreamStateOnline {
+		t.Errorf("api %d %+v", code, msg)
+	}
+	if code, _ := testAPI(t, router, http.MethodGet, "/api/streams/test-missing/status", ""); code != http.StatusNotFound {
+		t.Errorf("api missing %d", code)
+	}
+}
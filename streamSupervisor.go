@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	StreamStateStopped      = "stopped"
+	StreamStateConnecting   = "connecting"
+	StreamStateOnline       = "online"
+	StreamStateReconnecting = "reconnecting"
+	StreamStateFailed       = "failed"
+)
+
+const (
+	DefaultReconnectInitial    = 1000 //ms
+	DefaultReconnectMultiplier = 2
+	DefaultReconnectMax        = 60000 //ms
+	DefaultReconnectJitter     = 0.2
+	FailureHistory             = 20 //failures kept per stream
+)
+
+//StreamStateST worker state for api
+type StreamStateST struct {
+	State     string      `json:"state"`
+	Since     time.Time   `json:"since"`
+	Attempts  int         `json:"attempts"`
+	Retry     *time.Time  `json:"retry,omitempty"`
+	Connected *time.Time  `json:"connected,omitempty"`
+	Source    int         `json:"source"`
+	Failures  []FailureST `json:"failures"`
+}
+
+//FailureST failure reason with time
+type FailureST struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+}
+
+//BackoffST reconnect policy
+type BackoffST struct {
+	Initial     time.Duration //First delay
+	Multiplier  float64       //Delay grow per attempt
+	Max         time.Duration //Delay limit
+	Jitter      float64       //Random part of delay 0-1
+	MaxAttempts int           //Failed state after attempts 0 unlimited
+}
+
+//Delay for attempt from 1 with jitter, never above Max or below zero
+func (element BackoffST) Delay(attempt int) time.Duration {
+	delay := float64(element.Initial) * math.Pow(element.Multiplier, float64(attempt-1))
+	if delay > float64(element.Max) {
+		delay = float64(element.Max)
+	}
+	delay += delay * element.Jitter * (2*rand.Float64() - 1)
+	if delay > float64(element.Max) {
+		delay = float64(element.Max)
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return time.Duration(delay)
+}
+
+//Supervisor own stream worker context and state
+type Supervisor struct {
+	mutex     sync.RWMutex
+	UUID      string             //Stream UUID
+	State     string             //Worker state
+	Since     time.Time          //Last state change
+	Attempts  int                //Failed connect attempts since online
+	Retry     time.Time          //Next connect after backoff
+	Failures  []FailureST        //Last failures
+	Failed    bool               //Max attempts reached wait operator reset
+	Source    int                //Active source index 0 primary
+	Connected time.Time          //Last source session online
+	cancel    context.CancelFunc //Cancel running worker context
+	reconnect chan struct{}      //Drop source keep worker loop
+	done      chan struct{}      //Closed when worker loop exit
+}
+
+//NewSupervisor new stopped supervisor
+func NewSupervisor(uuid string) *Supervisor {
+	done := make(chan struct{})
+	close(done)
+	return &Supervisor{
+		UUID:      uuid,
+		State:     StreamStateStopped,
+		Since:     time.Now(),
+		reconnect: make(chan struct{}, 1),
+		done:      done,
+	}
+}
+
+//Start worker loop if not run
+func (element *Supervisor) Start() {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	if element.cancel != nil || element.Failed {
+		return
+	}
+	//previous loop still exiting
+	select {
+	case <-element.done:
+	default:
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	element.cancel = cancel
+	element.done = make(chan struct{})
+	element.setState(StreamStateConnecting)
+	go element.loop(ctx, element.done)
+}
+
+//Stop cancel worker loop return chan closed on exit
+func (element *Supervisor) Stop() <-chan struct{} {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	if element.cancel != nil {
+		element.cancel()
+		element.cancel = nil
+	}
+	return element.done
+}
+
+//Restart stop wait and start new worker loop
+func (element *Supervisor) Restart() {
+	<-element.Stop()
+	element.Start()
+}
+
+//Reset operator clear failed state and start
+func (element *Supervisor) Reset() {
+	element.mutex.Lock()
+	element.Failed = false
+	element.Attempts = 0
+	element.mutex.Unlock()
+	element.Start()
+}
+
+//failure record reason return true if max attempts reached
+func (element *Supervisor) failure(err error, maxAttempts int) bool {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	element.Attempts++
+	element.record(err)
+	if maxAttempts > 0 && element.Attempts >= maxAttempts {
+		element.Failed = true
+	}
+	return element.Failed
+}
+
+//record failure reason keep last history, call under lock
+func (element *Supervisor) record(err error) {
+	element.Failures = append(element.Failures, FailureST{Time: time.Now(), Reason: err.Error()})
+	if len(element.Failures) > FailureHistory {
+		element.Failures = element.Failures[len(element.Failures)-FailureHistory:]
+	}
+}
+
+//failover switch to next source, record reason inside failover round
+func (element *Supervisor) failover(err error, sources int, record bool) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	if record {
+		element.record(err)
+	}
+	element.Source = (element.Source + 1) % sources
+	if sources > 1 {
+		log.Println(element.UUID, "Stream Failover Source", element.Source)
+		Webhook.Event(WebhookEventST{Event: WebhookEventFailover, Stream: element.UUID, State: element.State, Source: element.Source, Reason: err.Error()})
+	}
+}
+
+//source active source index inside current source list
+func (element *Supervisor) source(sources int) int {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	//edit can shorten source list
+	if element.Source >= sources {
+		element.Source = 0
+	}
+	return element.Source
+}
+
+//failback probe primary while on backup, healthy primary reconnect to it
+func (element *Supervisor) failback(ctx context.Context, url string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := RTSPProbe(ctx, url); err != nil {
+			log.Println(element.UUID, "Stream Failback Probe", err)
+			continue
+		}
+		element.mutex.Lock()
+		element.Source = 0
+		Webhook.Event(WebhookEventST{Event: WebhookEventFailover, Stream: element.UUID, State: element.State, Reason: "failback primary source healthy"})
+		element.mutex.Unlock()
+		log.Println(element.UUID, "Stream Failback Primary Source")
+		element.Reconnect()
+		return
+	}
+}
+
+//Reconnect drop source session keep worker loop and muxer
+func (element *Supervisor) Reconnect() {
+	select {
+	case element.reconnect <- struct{}{}:
+	default:
+	}
+}
+
+//Running worker loop active
+func (element *Supervisor) Running() bool {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	return element.cancel != nil
+}
+
+//SetState update state
+func (element *Supervisor) SetState(state string) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	element.setState(state)
+}
+
+//setState update state, call under lock
+func (element *Supervisor) setState(state string) {
+	if element.State == state {
+		return
+	}
+	log.Println(element.UUID, "Stream State", element.State, "->", state)
+	if element.State == StreamStateOnline {
+		event := WebhookEventST{Event: WebhookEventOffline, Stream: element.UUID, State: state, Source: element.Source}
+		if len(element.Failures) > 0 {
+			event.Reason = element.Failures[len(element.Failures)-1].Reason
+		}
+		Webhook.Event(event)
+	}
+	element.State = state
+	element.Since = time.Now()
+	switch state {
+	case StreamStateOnline:
+		element.Attempts = 0
+		element.Connected = element.Since
+		Webhook.Event(WebhookEventST{Event: WebhookEventOnline, Stream: element.UUID, State: state, Source: element.Source})
+	case StreamStateReconnecting:
+		Metrics.Reconnect(element.UUID)
+	}
+}
+
+//Event webhook event with stream state and active source
+func (element *Supervisor) Event(event WebhookEventST) {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	event.Stream = element.UUID
+	event.State = element.State
+	event.Source = element.Source
+	Webhook.Event(event)
+}
+
+//Info copy of state and change time
+func (element *Supervisor) Info() StreamStateST {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	res := StreamStateST{State: element.State, Since: element.Since, Attempts: element.Attempts, Source: element.Source}
+	if element.State == StreamStateReconnecting && !element.Retry.IsZero() {
+		retry := element.Retry
+		res.Retry = &retry
+	}
+	if element.State == StreamStateOnline {
+		connected := element.Connected
+		res.Connected = &connected
+	}
+	res.Failures = append([]FailureST{}, element.Failures...)
+	return res
+}
+
+//loop connect and reconnect until context cancel or on demand idle
+func (element *Supervisor) loop(ctx context.Context, done chan struct{}) {
+	state := StreamStateStopped
+	defer func() {
+		//worker crash mark failed not kill server
+		if r := recover(); r != nil {
+			log.Println(element.UUID, "Stream Worker Panic", r)
+			state = StreamStateFailed
+		}
+		Config.release(element.UUID, element)
+		element.mutex.Lock()
+		if element.cancel != nil {
+			element.cancel()
+			element.cancel = nil
+		}
+		element.setState(state)
+		element.mutex.Unlock()
+		close(done)
+	}()
+	//drop reconnect request from before start
+	select {
+	case <-element.reconnect:
+	default:
+	}
+	//new loop start on primary source
+	element.mutex.Lock()
+	element.Source = 0
+	element.mutex.Unlock()
+	//sources failed in a row, full round counts one attempt
+	var tried int
+	for {
+		//sources read each connect edit reconnect new source
+		sources, failback, ok := Config.sources(element.UUID)
+		if !ok {
+			return
+		}
+		source := element.source(len(sources))
+		element.SetState(StreamStateConnecting)
+		log.Println(element.UUID, "Stream Try Connect Source", source)
+		session, cancel := context.WithCancel(ctx)
+		if source > 0 && failback > 0 {
+			go element.failback(session, sources[0], failback)
+		}
+		err := RTSPWorker(session, element, sources[source])
+		cancel()
+		if err != nil {
+			log.Println(element.UUID, err)
+		}
+		//on demand no requests in idle period stop worker
+		if ctx.Err() != nil || !Config.HasViewer(element.UUID) {
+			return
+		}
+		if err == ErrorStreamReconnect {
+			tried = 0
+			element.SetState(StreamStateReconnecting)
+			continue
+		}
+		element.mutex.RLock()
+		online := element.State == StreamStateOnline
+		element.mutex.RUnlock()
+		//connect or no video error fail over, disconnect of online source retry it first
+		if !online || err == ErrorStreamExitNoVideoOnStream {
+			tried++
+			if tried < len(sources) {
+				element.failover(err, len(sources), true)
+				element.SetState(StreamStateReconnecting)
+				continue
+			}
+			//all sources failed next round after backoff
+			element.failover(err, len(sources), false)
+		}
+		tried = 0
+		backoff := Config.backoff(element.UUID)
+		if element.failure(err, backoff.MaxAttempts) {
+			log.Println(element.UUID, "Stream Max Reconnect Attempts Wait Reset")
+			state = StreamStateFailed
+			return
+		}
+		element.mutex.Lock()
+		delay := backoff.Delay(element.Attempts)
+		element.Retry = time.Now().Add(delay)
+		element.setState(StreamStateReconnecting)
+		element.mutex.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	backoff := BackoffST{Initial: time.Second, Multiplier: 2, Max: time.Minute}
+	for attempt, want := range map[int]time.Duration{1: time.Second, 4: 8 * time.Second, 7: time.Minute, 40: time.Minute} {
+		if delay := backoff.Delay(attempt); delay != want {
+			t.Errorf("attempt %d delay %v want %v", attempt, delay, want)
+		}
+	}
+	//jitter around delay, at max only below
+	backoff.Jitter = 0.5
+	for i := 0; i < 1000; i++ {
+		if delay := backoff.Delay(3); delay < 2*time.Second || delay > 6*time.Second {
+			t.Fatalf("jitter delay %v", delay)
+		}
+		if delay := backoff.Delay(20); delay < 30*time.Second || delay > time.Minute {
+			t.Fatalf("jitter at max %v", delay)
+		}
+	}
+	//jitter over 1 from code policy never negative
+	backoff.Jitter = 3
+	for i := 0; i < 1000; i++ {
+		if delay := backoff.Delay(1); delay < 0 || delay > time.Minute {
+			t.Fatalf("large jitter %v", delay)
+		}
+	}
+}
+
+func TestSupervisorFailed(t *testing.T) {
+	supervisor := testStream(t, "test-failed", StreamST{URL: "rtsp://127.0.0.1:1/test", ReconnectInitialMS: 10, ReconnectMaxMS: 20, ReconnectMaxAttempts: 3})
+	supervisor.Start()
+	testWaitState(t, supervisor, StreamStateFailed, 5*time.Second)
+	info := supervisor.Info()
+	if info.Attempts != 3 || len(info.Failures) != 3 || info.Retry != nil {
+		t.Fatalf("failed %+v", info)
+	}
+	//failed stay failed until reset
+	supervisor.Start()
+	if supervisor.Running() || supervisor.Info().State != StreamStateFailed {
+		t.Error("start leave failed state")
+	}
+	//failed state set just before loop exit
+	<-supervisor.Stop()
+	if err := Config.StreamControl("test-failed", "reset"); err != nil {
+		t.Fatal(err)
+	}
+	testWaitState(t, supervisor, StreamStateFailed, 5*time.Second)
+	if info = supervisor.Info(); info.Attempts != 3 || len(info.Failures) != 6 {
+		t.Errorf("after reset %+v", info)
+	}
+	//history keep last failures only
+	for i := 0; i < FailureHistory; i++ {
+		<-supervisor.Stop()
+		supervisor.Reset()
+		testWaitState(t, supervisor, StreamStateFailed, 5*time.Second)
+	}
+	if info = supervisor.Info(); len(info.Failures) != FailureHistory || info.Failures[0].Reason == "" {
+		t.Errorf("failures %d want %d", len(info.Failures), FailureHistory)
+	}
+}
+
+func TestSupervisorFailover(t *testing.T) {
+	primary := testRTSPServer(t)
+	atomic.StoreInt32(&primary.down, 1)
+	backup := testRTSPServer(t)
+	supervisor := testStream(t, "test-failover", StreamST{URL: primary.URL, URLs: []string{"rtsp://127.0.0.1:1/test", backup.URL}, FPSMode: "fixed", Failback: true, FailbackCheck: 1})
+	supervisor.Start()
+	testWaitState(t, supervisor, StreamStateOnline, 5*time.Second)
+	//primary and second backup failed in one round, no backoff attempt
+	info := supervisor.Info()
+	if info.Source != 2 || info.Attempts != 0 || len(info.Failures) != 2 {
+		t.Fatalf("failover %+v", info)
+	}
+	//healthy primary probed and taken back
+	atomic.StoreInt32(&primary.down, 0)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if info = supervisor.Info(); info.Source == 0 && info.State == StreamStateOnline {
+			break
+		}
+	}
+	if info.Source != 0 || info.State != StreamStateOnline {
+		t.Fatalf("failback %+v", info)
+	}
+	//probe plus worker session
+	if sessions := atomic.LoadInt32(&primary.sessions); sessions != 2 {
+		t.Errorf("primary sessions %d", sessions)
+	}
+}
+
+func TestSupervisorFailoverRound(t *testing.T) {
+	supervisor := testStream(t, "test-round", StreamST{URL: "rtsp://127.0.0.1:1/a", URLs: []string{"rtsp://127.0.0.1:2/b", "rtsp://127.0.0.1:1/a"}, ReconnectInitialMS: 10, ReconnectMaxAttempts: 2})
+	supervisor.Start()
+	testWaitState(t, supervisor, StreamStateFailed, 5*time.Second)
+	//duplicate source skipped, full round one attempt, sources tried in order
+	info := supervisor.Info()
+	if info.Attempts != 2 || len(info.Failures) != 4 {
+		t.Fatalf("round %+v", info)
+	}
+	for i, port := range []string{":1:", ":2:", ":1:", ":2:"} {
+		if !strings.Contains(info.Failures[i].Reason, port) {
+			t.Errorf("failure %d %s want port %s", i, info.Failures[i].Reason, port)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+const (
+	TimeLineWrapRTP90K  = time.Duration(1<<32) * time.Second / 90000 //32-bit RTP wrap at 90kHz
+	TimeLineGap         = 3 * time.Second                            //jump over gap is discontinuity
+	TimeLineOutlier     = 4                                          //duration outside average*/4 is outlier
+	TimeLineSmooth      = 8                                          //EWMA and drift correction factor
+	TimeLineMinDuration = time.Millisecond
+)
+
+//TimeLine track decode time line from source timestamps
+type TimeLine struct {
+	Wrap            time.Duration //Source timestamp wrap period 0 no wrap
+	Fallback        time.Duration //Fallback duration fps or audio frame size
+	Fixed           bool          //Ignore source time always use fallback audio
+	Merge           bool          //Merge packets with same time one access unit
+	Discontinuities int           //Detected source discontinuities
+	Outliers        int           //Clamped outlier durations
+	Reorders        int           //Reordered source times B-frames in presentation order
+	started         bool          //First packet seen
+	raw             time.Duration //Last raw source time
+	source          time.Duration //Unwrapped source position
+	top             time.Duration //Highest unwrapped source position
+	delay           time.Duration //Composition delay keep reordered offsets positive
+	dts             time.Duration //Output decode time
+	average         time.Duration //Smoothed duration
+	pending         *av.Packet    //Packet wait next for duration
+}
+
+//NewTimeLine new track time line start at dts
+func NewTimeLine(start time.Duration, wrap time.Duration, fallback time.Duration) *TimeLine {
+	return &TimeLine{
+		Wrap:     wrap,
+		Fallback: fallback,
+		source:   start,
+		top:      start,
+		dts:      start,
+	}
+}
+
+//SetFallback update fallback duration fps change
+func (element *TimeLine) SetFallback(val time.Duration) {
+	element.Fallback = val
+}
+
+//Push packet return packets ready with Time and Duration, one packet lookahead
+func (element *TimeLine) Push(packet *av.Packet) []*av.Packet {
+	if element.Fixed {
+		packet.Duration = element.Fallback
+		packet.Time = element.dts
+		element.dts += packet.Duration
+		return []*av.Packet{packet}
+	}
+	raw := packet.Time
+	if !element.started {
+		element.started = true
+		element.raw = raw
+		packet.Time = element.dts
+		element.pending = packet
+		return nil
+	}
+	delta := raw - element.raw
+	element.raw = raw
+	//32-bit timestamp wrap around
+	if element.Wrap > 0 && delta < -element.Wrap/2 {
+		delta += element.Wrap
+	} else if element.Wrap > 0 && delta > element.Wrap/2 {
+		delta -= element.Wrap
+	}
+	if delta == 0 && element.Merge {
+		element.pending.Data = append(element.pending.Data, packet.Data...)
+		element.pending.IsKeyFrame = element.pending.IsKeyFrame || packet.IsKeyFrame
+		packet.Time = element.pending.Time
+		return nil
+	}
+	duration := element.duration(delta)
+	res := element.pending
+	res.Duration = duration
+	element.dts += duration
+	packet.Time = element.dts
+	if element.Reorders > 0 {
+		//presentation from source position, delay only grow
+		cts := element.source - element.dts + element.delay
+		if cts < 0 {
+			element.delay -= cts
+			cts = 0
+		}
+		packet.CompositionTime = cts
+	}
+	element.pending = packet
+	return []*av.Packet{res}
+}
+
+//Skip advance time line over lost source frames
+func (element *TimeLine) Skip(val time.Duration) {
+	element.dts += val
+}
+
+//duration calc output duration from source delta
+func (element *TimeLine) duration(delta time.Duration) time.Duration {
+	base := element.average
+	if base == 0 {
+		base = element.Fallback
+	}
+	switch {
+	case delta == 0 || delta > TimeLineGap || delta < -TimeLineGap:
+		//discontinuity resync source to output keep time line continuous
+		element.Discontinuities++
+		res := base
+		if res <= 0 {
+			res = TimeLineMinDuration
+		}
+		element.source = element.dts + res
+		element.top = element.source
+		return res
+	case delta < 0 || element.Reorders > 0:
+		return element.reordered(delta, base)
+	case base > 0 && (delta > base*TimeLineOutlier || delta < base/TimeLineOutlier):
+		element.Outliers++
+		element.source += delta
+		element.top = element.source
+		res := delta
+		if res > base*TimeLineOutlier {
+			res = base * TimeLineOutlier
+		} else if res < base/TimeLineOutlier {
+			res = base / TimeLineOutlier
+		}
+		return res
+	}
+	element.source += delta
+	element.top = element.source
+	if element.average == 0 {
+		element.average = delta
+	} else {
+		element.average += (delta - element.average) / TimeLineSmooth
+	}
+	//smooth jitter pull output to source slowly
+	res := element.average + (element.source-element.dts-element.average)/TimeLineSmooth
+	if res < TimeLineMinDuration {
+		res = TimeLineMinDuration
+	}
+	return res
+}
+
+//reordered source time in presentation order, duration from presentation progress
+func (element *TimeLine) reordered(delta time.Duration, base time.Duration) time.Duration {
+	if delta < 0 {
+		element.Reorders++
+	}
+	element.source += delta
+	var progress time.Duration
+	if element.source > element.top {
+		progress = element.source - element.top
+		element.top = element.source
+	}
+	if element.average == 0 {
+		element.average = base
+	}
+	//progress lumpy P-frame carry B-frames, sum exact
+	element.average += (progress - element.average) / TimeLineSmooth
+	//decode trail highest presentation one frame, pull slowly
+	res := element.average + (element.top-element.dts-element.average)/TimeLineSmooth
+	if res < TimeLineMinDuration {
+		res = TimeLineMinDuration
+	}
+	return res
+}
+
+//Flush return pending packet with average duration
+func (element *TimeLine) Flush() *av.Packet {
+	res := element.pending
+	if res == nil {
+		return nil
+	}
+	element.pending = nil
+	res.Duration = element.average
+	if res.Duration == 0 {
+		res.Duration = element.Fallback
+	}
+	if res.Duration < TimeLineMinDuration {
+		res.Duration = TimeLineMinDuration
+	}
+	element.dts += res.Duration
+	return res
+}
+
+//DTS next output decode time
+func (element *TimeLine) DTS() time.Duration {
+	return element.dts
+}
+
+const (
+	ntpEpochOffset   = 2208988800 //seconds 1900 to 1970
+	rtcpSenderReport = 200
+	WallClockSmooth  = 1000 //arrival drift correction factor per packet, 40s at 25 fps
+)
+
+//WallClock map track decode time to wall clock, RTCP sender report or arrival
+type WallClock struct {
+	anchorWall time.Time     //Wall clock of anchor packet
+	anchorDTS  time.Duration //Decode time of anchor packet
+	srWall     time.Time     //Last sender report NTP time
+	srRTP      uint32        //Last sender report RTP timestamp
+	srRate     int64         //RTP clock rate
+}
+
+//NewWallClock new wall clock for RTP clock rate
+func NewWallClock(rate int64) *WallClock {
+	return &WallClock{srRate: rate}
+}
+
+//SenderReport update NTP mapping from RTCP sender report
+func (element *WallClock) SenderReport(ntp time.Time, rtp uint32) {
+	element.srWall = ntp
+	element.srRTP = rtp
+}
+
+//Update anchor packet raw source time to decode time, arrival used without sender report
+func (element *WallClock) Update(raw time.Duration, dts time.Duration, arrival time.Time) {
+	if !element.srWall.IsZero() {
+		//client source time is RTP timestamp / (rate/1000) in ms
+		rtp := uint32(raw.Milliseconds() * element.srRate / 1000)
+		diff := int64(int32(rtp - element.srRTP))
+		element.anchorDTS = dts
+		element.anchorWall = element.srWall.Add(time.Duration(diff) * time.Second / time.Duration(element.srRate))
+		return
+	}
+	//arrival anchor once, later wall from decode time
+	drift := arrival.Sub(element.Wall(dts))
+	if element.anchorWall.IsZero() || drift > TimeLineGap || drift < -TimeLineGap {
+		element.anchorDTS = dts
+		element.anchorWall = arrival
+		return
+	}
+	//network jitter average out, slow follow source clock drift
+	element.anchorWall = element.anchorWall.Add(drift / WallClockSmooth)
+}
+
+//Reset anchor again on next update, source time line discontinuity
+func (element *WallClock) Reset() {
+	element.anchorWall = time.Time{}
+}
+
+//Wall clock for decode time
+func (element *WallClock) Wall(dts time.Duration) time.Time {
+	if element.anchorWall.IsZero() {
+		return time.Now()
+	}
+	return element.anchorWall.Add(dts - element.anchorDTS)
+}
+
+//parseRTCPSenderReport find sender report in compound RTCP packet
+func parseRTCPSenderReport(payload []byte) (time.Time, uint32, bool) {
+	for len(payload) >= 4 {
+		size := (int(binary.BigEndian.Uint16(payload[2:])) + 1) * 4
+		if payload[0]>>6 != 2 || size > len(payload) {
+			return time.Time{}, 0, false
+		}
+		if payload[1] == rtcpSenderReport && size >= 28 {
+			sec := binary.BigEndian.Uint32(payload[8:])
+			frac := binary.BigEndian.Uint32(payload[12:])
+			//camera without NTP send zero
+			if sec == 0 {
+				return time.Time{}, 0, false
+			}
+			nsec := int64(frac) * int64(time.Second) >> 32
+			return time.Unix(int64(sec)-ntpEpochOffset, nsec), binary.BigEndian.Uint32(payload[16:]), true
+		}
+		payload = payload[size:]
+	}
+	return time.Time{}, 0, false
+}
+
+//SenderReportST RTCP sender report NTP and RTP time
+type SenderReportST struct {
+	NTP time.Time
+	RTP uint32
+}
+
+//RTPProxyST drain client raw interleaved queue off worker loop, client disconnect when queue full
+type RTPProxyST struct {
+	lost         int64 //Lost audio samples not taken, first for 64-bit atomic align
+	rtcpChannel  int
+	audioChannel int
+	audioRate    int64
+	audioNext    uint32 //Expected RTP timestamp of next audio packet
+	audioStarted bool
+	Reports      chan SenderReportST //Latest sender report only
+}
+
+//NewRTPProxy new drain for video RTCP and AAC RTP channel, -1 none
+func NewRTPProxy(rtcpChannel int, audioChannel int, audioRate int64) *RTPProxyST {
+	return &RTPProxyST{rtcpChannel: rtcpChannel, audioChannel: audioChannel, audioRate: audioRate, Reports: make(chan SenderReportST, 1)}
+}
+
+//Run drain queue until ctx done, only headers parsed never block on worker
+func (element *RTPProxyST) Run(ctx context.Context, queue chan *[]byte) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case content := <-queue:
+			if len(*content) <= 4 {
+				continue
+			}
+			switch int((*content)[1]) {
+			case element.rtcpChannel:
+				element.report((*content)[4:])
+			case element.audioChannel:
+				element.audio((*content)[4:])
+			}
+		}
+	}
+}
+
+//AudioLost take lost audio time since last call
+func (element *RTPProxyST) AudioLost() time.Duration {
+	if element.audioRate == 0 {
+		return 0
+	}
+	return time.Duration(atomic.SwapInt64(&element.lost, 0)) * time.Second / time.Duration(element.audioRate)
+}
+
+//report forward video sender report
+func (element *RTPProxyST) report(payload []byte) {
+	ntp, rtp, ok := parseRTCPSenderReport(payload)
+	if !ok {
+		return
+	}
+	//replace unread report, single sender never block
+	select {
+	case <-element.Reports:
+	default:
+	}
+	element.Reports <- SenderReportST{NTP: ntp, RTP: rtp}
+}
+
+//audio count whole frames missing between AAC packets, client time only count received frames
+func (element *RTPProxyST) audio(payload []byte) {
+	ts, frames, ok := parseRTPAAC(payload)
+	if !ok {
+		return
+	}
+	if element.audioStarted {
+		//jump back or over gap is source reset not loss
+		gap := int64(int32(ts - element.audioNext))
+		if gap >= 1024 && gap <= int64(TimeLineGap/time.Second)*element.audioRate {
+			atomic.AddInt64(&element.lost, gap/1024*1024)
+		}
+	}
+	element.audioStarted = true
+	element.audioNext = ts + uint32(frames*1024)
+}
+
+//parseRTPAAC RTP timestamp and AAC frame count from 16-bit AU headers same as client
+func parseRTPAAC(payload []byte) (uint32, int, bool) {
+	if len(payload) < 12 || payload[0]>>6 != 2 {
+		return 0, 0, false
+	}
+	offset := 12 + 4*int(payload[0]&0x0f)
+	if payload[0]&0x10 != 0 {
+		if len(payload) < offset+4 {
+			return 0, 0, false
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(payload[offset+2:]))
+	}
+	if len(payload) < offset+2 {
+		return 0, 0, false
+	}
+	frames := int(binary.BigEndian.Uint16(payload[offset:]) >> 4)
+	if frames == 0 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint32(payload[4:]), frames, true
+}
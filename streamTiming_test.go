@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/deepch/vdk/av"
+)
+
+//testTimeLinePush push raw source times through video time line, check output continuous
+func testTimeLinePush(t *testing.T, timeLine *TimeLine, raw []time.Duration) (res []*av.Packet) {
+	t.Helper()
+	for _, val := range raw {
+		res = append(res, timeLine.Push(&av.Packet{Time: val, Data: []byte{1}})...)
+	}
+	for i, packet := range res {
+		if packet.Duration < TimeLineMinDuration {
+			t.Fatalf("packet %d duration %v", i, packet.Duration)
+		}
+		if i > 0 && packet.Time != res[i-1].Time+res[i-1].Duration {
+			t.Fatalf("packet %d time %v not continuous", i, packet.Time)
+		}
+		if packet.CompositionTime < 0 {
+			t.Fatalf("packet %d composition %v", i, packet.CompositionTime)
+		}
+	}
+	return res
+}
+
+func TestTimeLineSteady(t *testing.T) {
+	//start before 32-bit wrap, source time restart near zero
+	timeLine := NewTimeLine(0, TimeLineWrapRTP90K, 0)
+	var raw []time.Duration
+	for i := 0; i < 100; i++ {
+		raw = append(raw, (TimeLineWrapRTP90K-2*time.Second+time.Duration(i)*40*time.Millisecond)%TimeLineWrapRTP90K)
+	}
+	packets := testTimeLinePush(t, timeLine, raw)
+	if len(packets) != 99 {
+		t.Fatalf("packets %d want 99 one pending", len(packets))
+	}
+	for i, packet := range packets {
+		if packet.Duration != 40*time.Millisecond {
+			t.Fatalf("packet %d duration %v", i, packet.Duration)
+		}
+	}
+	if timeLine.Discontinuities != 0 || timeLine.Outliers != 0 {
+		t.Errorf("discontinuities %d outliers %d on wrap", timeLine.Discontinuities, timeLine.Outliers)
+	}
+}
+
+func TestTimeLineJitter(t *testing.T) {
+	timeLine := NewTimeLine(0, TimeLineWrapRTP90K, 0)
+	var raw []time.Duration
+	for i := 0; i < 200; i++ {
+		raw = append(raw, time.Hour+time.Duration(i)*40*time.Millisecond+time.Duration(i%3-1)*5*time.Millisecond)
+	}
+	packets := testTimeLinePush(t, timeLine, raw)
+	//output follow source, no drift
+	end := packets[len(packets)-1].Time + packets[len(packets)-1].Duration
+	if diff := end - 199*40*time.Millisecond; diff > 20*time.Millisecond || diff < -20*time.Millisecond {
+		t.Errorf("end %v drift %v", end, diff)
+	}
+	for i, packet := range packets[10:] {
+		if packet.Duration < 35*time.Millisecond || packet.Duration > 45*time.Millisecond {
+			t.Fatalf("packet %d duration %v not smoothed", i+10, packet.Duration)
+		}
+	}
+}
+
+func TestTimeLineDiscontinuity(t *testing.T) {
+	for _, jump := range []time.Duration{time.Minute, -time.Hour} {
+		timeLine := NewTimeLine(0, TimeLineWrapRTP90K, 0)
+		var raw []time.Duration
+		for i := 0; i < 100; i++ {
+			val := time.Hour + time.Duration(i)*40*time.Millisecond
+			if i >= 50 {
+				val += jump
+			}
+			raw = append(raw, val)
+		}
+		packets := testTimeLinePush(t, timeLine, raw)
+		if timeLine.Discontinuities != 1 {
+			t.Errorf("jump %v discontinuities %d want 1", jump, timeLine.Discontinuities)
+		}
+		//jump replaced by one average frame
+		if end := packets[len(packets)-1].Time + packets[len(packets)-1].Duration; end != 99*40*time.Millisecond {
+			t.Errorf("jump %v end %v", jump, end)
+		}
+	}
+}
+
+func TestTimeLineOutlier(t *testing.T) {
+	timeLine := NewTimeLine(0, TimeLineWrapRTP90K, 0)
+	var raw []time.Duration
+	for i := 0; i < 100; i++ {
+		val := time.Hour + time.Duration(i)*40*time.Millisecond
+		if i >= 50 {
+			val += time.Second
+		}
+		raw = append(raw, val)
+	}
+	packets := testTimeLinePush(t, timeLine, raw)
+	if timeLine.Outliers != 1 {
+		t.Errorf("outliers %d want 1", timeLine.Outliers)
+	}
+	for i, packet := range packets {
+		if packet.Duration > 40*time.Millisecond*TimeLineOutlier {
+			t.Fatalf("packet %d duration %v not clamped", i, packet.Duration)
+		}
+	}
+	//clamped second recovered slowly by drift correction
+	end := packets[len(packets)-1].Time + packets[len(packets)-1].Duration
+	if diff := end - 99*40*time.Millisecond - time.Second; diff > 10*time.Millisecond || diff < -10*time.Millisecond {
+		t.Errorf("end %v drift %v", end, diff)
+	}
+}
+
+func TestTimeLineReorder(t *testing.T) {
+	//IBBP in decode order, source time is presentation time
+	timeLine := NewTimeLine(0, TimeLineWrapRTP90K, 40*time.Millisecond)
+	var raw []time.Duration
+	for g := 0; g < 30; g++ {
+		for _, f := range []int{0, 3, 1, 2} {
+			raw = append(raw, time.Duration(g*4+f)*40*time.Millisecond)
+		}
+	}
+	packets := testTimeLinePush(t, timeLine, raw)
+	if timeLine.Reorders == 0 {
+		t.Fatal("reorder not detected")
+	}
+	//after start delay settle presentation step follow source
+	for i := 40; i < len(packets); i++ {
+		want := raw[i] - raw[i-1]
+		got := packets[i].Time + packets[i].CompositionTime - packets[i-1].Time - packets[i-1].CompositionTime
+		if got != want {
+			t.Fatalf("packet %d presentation step %v want %v", i, got, want)
+		}
+	}
+}
+
+func TestTimeLineMergeFlush(t *testing.T) {
+	timeLine := NewTimeLine(0, TimeLineWrapRTP90K, 0)
+	timeLine.Merge = true
+	//two nal same timestamp one access unit
+	packets := testTimeLinePush(t, timeLine, []time.Duration{0, 0, 40 * time.Millisecond, 80 * time.Millisecond})
+	if len(packets) != 2 {
+		t.Fatalf("packets %d want 2", len(packets))
+	}
+	if len(packets[0].Data) != 2 {
+		t.Errorf("merged data %d want 2", len(packets[0].Data))
+	}
+	res := timeLine.Flush()
+	if res == nil || res.Time != 80*time.Millisecond || res.Duration != 40*time.Millisecond {
+		t.Fatalf("flush %+v", res)
+	}
+	if timeLine.Flush() != nil {
+		t.Error("second flush not empty")
+	}
+	if timeLine.DTS() != 120*time.Millisecond {
+		t.Errorf("dts %v after flush", timeLine.DTS())
+	}
+	//nothing pending before two packets, fallback floor
+	timeLine = NewTimeLine(0, TimeLineWrapRTP90K, 0)
+	timeLine.Push(&av.Packet{Time: time.Second})
+	if res := timeLine.Flush(); res == nil || res.Duration != TimeLineMinDuration {
+		t.Errorf("single flush %+v", res)
+	}
+}
+
+func TestTimeLineFixedSkip(t *testing.T) {
+	frame := 1024 * time.Second / 48000
+	timeLine := NewTimeLine(time.Second, 0, frame)
+	timeLine.Fixed = true
+	want := time.Second
+	for i := 0; i < 6; i++ {
+		if i == 3 {
+			//two frames lost
+			timeLine.Skip(2 * frame)
+			want += 2 * frame
+		}
+		//source time ignored
+		packets := timeLine.Push(&av.Packet{Time: time.Hour})
+		if len(packets) != 1 || packets[0].Time != want || packets[0].Duration != frame {
+			t.Fatalf("packet %d %+v want time %v", i, packets, want)
+		}
+		want += frame
+	}
+}
+
+//testRTPAAC interleaved AAC RTP packet, frames one byte AU each
+func testRTPAAC(channel byte, ts uint32, frames int) *[]byte {
+	res := []byte{0x24, channel, 0, 0, 0x80, 97, 0, 1, byte(ts >> 24), byte(ts >> 16), byte(ts >> 8), byte(ts), 0, 0, 0, 1, 0, byte(frames * 16)}
+	for i := 0; i < frames; i++ {
+		res = append(res, 0, 8)
+	}
+	for i := 0; i < frames; i++ {
+		res = append(res, 0xaa)
+	}
+	return &res
+}
+
+func TestParseRTPAAC(t *testing.T) {
+	ts, frames, ok := parseRTPAAC((*testRTPAAC(2, 7, 3))[4:])
+	if !ok || ts != 7 || frames != 3 {
+		t.Errorf("three frames got %d %d %v", ts, frames, ok)
+	}
+	//header extension one word before AU headers
+	ts, frames, ok = parseRTPAAC([]byte{0x90, 97, 0, 1, 0, 0, 0x10, 0, 0, 0, 0, 1, 0xbe, 0xde, 0, 1, 1, 2, 3, 4, 0, 32, 0, 8, 0, 8})
+	if !ok || ts != 4096 || frames != 2 {
+		t.Errorf("extension got %d %d %v", ts, frames, ok)
+	}
+	if _, _, ok := parseRTPAAC((*testRTPAAC(2, 7, 0))[4:]); ok {
+		t.Error("no AU headers accepted")
+	}
+	if _, _, ok := parseRTPAAC((*testRTPAAC(2, 7, 1))[4:14]); ok {
+		t.Error("truncated accepted")
+	}
+	if _, _, ok := parseRTPAAC(append([]byte{0x40}, (*testRTPAAC(2, 7, 1))[5:]...)); ok {
+		t.Error("version 1 accepted")
+	}
+}
+
+func TestRTPProxyAudioLost(t *testing.T) {
+	frame := 1024 * time.Second / 48000
+	tests := map[string]struct {
+		ts   []uint32
+		lost time.Duration
+	}{
+		"continuous":             {ts: []uint32{0, 1024, 2048, 3072}},
+		"one packet lost":        {ts: []uint32{0, 1024, 3072}, lost: frame},
+		"lost across wrap":       {ts: []uint32{1<<32 - 1024, 1024}, lost: frame},
+		"jump back is reset":     {ts: []uint32{48000, 0, 1024}},
+		"jump over gap is reset": {ts: []uint32{0, 48000 * 10}},
+	}
+	for name, test := range tests {
+		proxy := NewRTPProxy(-1, 2, 48000)
+		//unbuffered, send return after previous packet handled
+		queue := make(chan *[]byte)
+		ctx, cancel := context.WithCancel(context.Background())
+		go proxy.Run(ctx, queue)
+		for _, ts := range test.ts {
+			queue <- testRTPAAC(2, ts, 1)
+		}
+		//video packet other channel ignored
+		queue <- testRTPAAC(0, 0, 1)
+		cancel()
+		if lost := proxy.AudioLost(); lost != test.lost {
+			t.Errorf("%s lost %v want %v", name, lost, test.lost)
+		}
+		if lost := proxy.AudioLost(); lost != 0 {
+			t.Errorf("%s lost after take %v", name, lost)
+		}
+	}
+}
+
+//testSenderReport RTCP sender report NTP seconds fraction and RTP timestamp
+func testSenderReport(sec uint32, frac uint32, rtp uint32) []byte {
+	res := make([]byte, 28)
+	copy(res, []byte{0x80, rtcpSenderReport, 0, 6, 0, 0, 0, 1})
+	binary.BigEndian.PutUint32(res[8:], sec)
+	binary.BigEndian.PutUint32(res[12:], frac)
+	binary.BigEndian.PutUint32(res[16:], rtp)
+	return res
+}
+
+func TestParseRTCPSenderReport(t *testing.T) {
+	sec := uint32(ntpEpochOffset + 1700000000)
+	ntp, rtp, ok := parseRTCPSenderReport(testSenderReport(sec, 1<<31, 90000))
+	if !ok || !ntp.Equal(time.Unix(1700000000, int64(time.Second/2))) || rtp != 90000 {
+		t.Errorf("sender report %v %d %v", ntp, rtp, ok)
+	}
+	//compound receiver report first
+	receiver := []byte{0x80, 201, 0, 1, 0, 0, 0, 1}
+	ntp, rtp, ok = parseRTCPSenderReport(append(receiver, testSenderReport(sec, 0, 7)...))
+	if !ok || !ntp.Equal(time.Unix(1700000000, 0)) || rtp != 7 {
+		t.Errorf("compound %v %d %v", ntp, rtp, ok)
+	}
+	for name, payload := range map[string][]byte{
+		"zero ntp":      testSenderReport(0, 0, 7),
+		"receiver only": receiver,
+		"bad version":   append([]byte{0x40}, testSenderReport(sec, 0, 7)[1:]...),
+		"truncated":     testSenderReport(sec, 0, 7)[:20],
+		"empty":         nil,
+	} {
+		if _, _, ok := parseRTCPSenderReport(payload); ok {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func TestWallClockArrival(t *testing.T) {
+	clock := NewWallClock(90000)
+	start := time.Unix(1700000000, 0)
+	//arrival jitter 0-30ms must not move mapping
+	for i := 0; i < 250; i++ {
+		dts := time.Duration(i) * 40 * time.Millisecond
+		clock.Update(dts, dts, start.Add(dts).Add(time.Duration(i%4)*10*time.Millisecond))
+	}
+	if diff := clock.Wall(10 * time.Second).Sub(start.Add(10 * time.Second)); diff < 0 || diff > 5*time.Millisecond {
+		t.Errorf("jitter moved wall %v", diff)
+	}
+	//source clock 0.1% slow, follow with lag
+	for i := 250; i < 2500; i++ {
+		dts := time.Duration(i) * 40 * time.Millisecond
+		clock.Update(dts, dts, start.Add(dts*1001/1000))
+	}
+	diff := clock.Wall(100 * time.Second).Sub(start.Add(100*time.Second + 100*time.Millisecond))
+	if diff > 0 || diff < -60*time.Millisecond {
+		t.Errorf("drift not followed %v", diff)
+	}
+	//arrival jump re-anchor, reset anchor next update
+	clock.Update(0, 100*time.Second, start.Add(time.Hour))
+	if got := clock.Wall(101 * time.Second); !got.Equal(start.Add(time.Hour + time.Second)) {
+		t.Errorf("jump wall %v", got)
+	}
+	clock.Reset()
+	clock.Update(0, 102*time.Second, start.Add(2*time.Hour))
+	if got := clock.Wall(102 * time.Second); !got.Equal(start.Add(2 * time.Hour)) {
+		t.Errorf("reset wall %v", got)
+	}
+}
+
+func TestWallClockSenderReport(t *testing.T) {
+	clock := NewWallClock(90000)
+	ntp := time.Unix(1600000000, 0)
+	clock.Update(time.Second, 10*time.Second, time.Unix(1700000000, 0))
+	//rtp 90000 at ntp, packet raw 2s is rtp 180000
+	clock.SenderReport(ntp, 90000)
+	clock.Update(2*time.Second, 10*time.Second, time.Unix(1700000000, 0))
+	if got := clock.Wall(11 * time.Second); !got.Equal(ntp.Add(2 * time.Second)) {
+		t.Errorf("wall %v want %v", got, ntp.Add(2*time.Second))
+	}
+}
+
+func TestRTPProxyReport(t *testing.T) {
+	proxy := NewRTPProxy(1, -1, 0)
+	queue := make(chan *[]byte)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go proxy.Run(ctx, queue)
+	for _, rtp := range []uint32{5, 6} {
+		report := append([]byte{0x24, 1, 0, 28}, testSenderReport(ntpEpochOffset+1, 0, rtp)...)
+		queue <- &report
+	}
+	//wait second report handled
+	queue <- &[]byte{0x24, 0, 0, 1, 0}
+	//unread report replaced by latest
+	select {
+	case res := <-proxy.Reports:
+		if res.RTP != 6 || !res.NTP.Equal(time.Unix(1, 0)) {
+			t.Errorf("report %+v", res)
+		}
+	default:
+		t.Fatal("no sender report")
+	}
+}
@@ -1,24 +1,77 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/deepch/vdk/av"
+	"github.com/deepch/vdk/codec/aacparser"
 	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
+	"github.com/deepch/vdk/format/rtsp/sdp"
 )
 
+//Success api response payload
+const Success = "success"
+
 var (
 	ErrorStreamNotFound            = errors.New("Stream Not Found")
 	ErrorStreamExitNoVideoOnStream = errors.New("Stream Exit No Video On Stream")
 	ErrorStreamExitRtspDisconnect  = errors.New("Stream Exit Rtsp Disconnect")
+	ErrorStreamExitNoViewer        = errors.New("Stream Exit On Demand No Viewer")
+	ErrorStreamNotReady            = errors.New("Stream Not Ready")
+	ErrorStreamAlreadyExists       = errors.New("Stream Already Exists")
+	ErrorStreamStopCoreSignal      = errors.New("Stream Stop Core Signal")
+	ErrorStreamReconnect           = errors.New("Stream Reconnect")
+	ErrorStreamShutdown            = errors.New("Stream Server Shutdown")
+	ErrorStreamOperationUnknown    = errors.New("Stream Operation Unknown")
 	ErrorStreamIndexTimeout        = errors.New("Stream Index Timeout")
 	ErrorStreamSegmentNotFound     = errors.New("Stream Segment Not Found")
 	ErrorStreamFragmentNotFound    = errors.New("Stream Fragment Not Found")
 	ErrorStreamFragmentTimeout     = errors.New("Stream Fragment Timeout")
+	ErrorStreamTrackNotFound       = errors.New("Stream Track Not Found")
+	ErrorStreamInitNotFound        = errors.New("Stream Init Not Found")
+	ErrorStreamHEVCSPSInvalid      = errors.New("Stream HEVC SPS Invalid")
+	ErrorStreamHlsOptionsNegative  = errors.New("Stream HLS Options Must Not Be Negative")
+	ErrorStreamPartTargetTooLong   = errors.New("Stream HLS Part Target Must Be Shorter Than Segment Min Duration")
+	ErrorStreamReconnectInvalid    = errors.New("Stream Reconnect Options Invalid")
+	ErrorStreamWindowTooShort      = errors.New("Stream HLS Max Segments Too Short For HOLD-BACK")
+	ErrorStreamSourceInvalid       = errors.New("Stream Source Urls Invalid")
+	ErrorStreamWebhookInvalid      = errors.New("Stream Webhook Url Or Event Invalid")
+	ErrorStreamRetentionNegative   = errors.New("Stream Record Retention Must Not Be Negative")
+	ErrorStreamArchiveRange        = errors.New("Stream Archive Start End Invalid")
+	ErrorStreamArchiveNotFound     = errors.New("Stream Archive Not Found")
+	ErrorStreamExportRange         = errors.New("Stream Export Start End Invalid Or Too Long")
+	ErrorStreamExportCodecChange   = errors.New("Stream Export Codec Change In Range")
+	ErrorStreamExportInvalid       = errors.New("Stream Export Segment Invalid")
+	ErrorStreamAPIUnauthorized     = errors.New("Stream API Unauthorized")
+	ErrorStreamAPIForbidden        = errors.New("Stream API Loopback Only Without api_token Or api_user")
+	ErrorStreamUUIDInvalid         = errors.New("Stream UUID Empty Or Not Path Safe")
+	ErrorStreamConfigLayout        = errors.New("Stream Config File Not A JSON Object")
 )
 
+//uuidValid stream uuid is path element of record dir, no separator dot dot or control
+func uuidValid(uuid string) bool {
+	if uuid == "" || uuid == "." || strings.Contains(uuid, "..") || strings.ContainsAny(uuid, `/\`) || !utf8.ValidString(uuid) {
+		return false
+	}
+	for _, r := range uuid {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
 //stringToInt convert string to int if err to zero
 func stringToInt(val string) int {
 	i, err := strconv.Atoi(val)
@@ -28,17 +81,215 @@ func stringToInt(val string) int {
 	return i
 }
 
+//parseTime unix seconds or RFC3339
+func parseTime(val string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, val)
+}
+
+//CredentialMask replace url user info in api response
+const CredentialMask = "xxxxx"
+
+//urlRedact url with user info masked, unparsable as is
+func urlRedact(val string) string {
+	tmp, err := url.Parse(val)
+	if err != nil || tmp.User == nil {
+		return val
+	}
+	tmp.User = url.User(CredentialMask)
+	return tmp.String()
+}
+
+//urlRestore stored url when client send back masked url from get
+func urlRestore(val string, old []string) string {
+	for _, tmp := range old {
+		if tmp != val && urlRedact(tmp) == val {
+			return tmp
+		}
+	}
+	return val
+}
+
+//stringInSlice check value in list
+func stringInSlice(val string, list []string) bool {
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+//fpsDuration frame duration for fps 0 if unknown
+func fpsDuration(fps int) time.Duration {
+	if fps <= 0 {
+		return 0
+	}
+	return time.Second / time.Duration(fps)
+}
+
 //UpdateGetFPS func
 func updateGetFPS(curFPS int, val []av.CodecData) int {
-	log.Println(h264parser.NALU_SPS)
 	for _, data := range val {
-		if data.Type().IsVideo() && data.Type() == av.H264 {
-			newFPS := int(data.(h264parser.CodecData).SPSInfo.FPS)
-			if curFPS != newFPS {
-				log.Println("fps sps update", curFPS, "new", newFPS)
-				curFPS = newFPS
+		var newFPS int
+		switch data.Type() {
+		case av.H264:
+			newFPS = int(data.(h264parser.CodecData).SPSInfo.FPS)
+		case av.H265:
+			codec := data.(h265parser.CodecData)
+			if len(codec.RecordInfo.SPS) == 0 {
+				continue
+			}
+			info, err := ParseHEVCSPS(codec.RecordInfo.SPS[0])
+			if err != nil {
+				log.Println("fps sps hevc parse error", err)
+				continue
 			}
+			newFPS = int(info.FPS)
+		default:
+			continue
+		}
+		if newFPS != 0 && curFPS != newFPS {
+			log.Println("fps sps update", curFPS, "new", newFPS)
+			curFPS = newFPS
 		}
 	}
 	return curFPS
 }
+
+//videoSize return video resolution hevc after conformance window
+func videoSize(codec av.CodecData) (int, int) {
+	switch codec.Type() {
+	case av.H264:
+		video := codec.(h264parser.CodecData)
+		return video.Width(), video.Height()
+	case av.H265:
+		video := codec.(h265parser.CodecData)
+		if len(video.RecordInfo.SPS) == 0 {
+			return 0, 0
+		}
+		info, err := ParseHEVCSPS(video.RecordInfo.SPS[0])
+		if err != nil {
+			return video.Width(), video.Height()
+		}
+		return int(info.Width), int(info.Height)
+	}
+	return 0, 0
+}
+
+//codecString RFC 6381 CODECS attribute
+func codecString(val []av.CodecData) string {
+	var res []string
+	for _, data := range val {
+		switch data.Type() {
+		case av.H264:
+			record := data.(h264parser.CodecData).RecordInfo
+			res = append(res, fmt.Sprintf("avc1.%02x%02x%02x", record.AVCProfileIndication, record.ProfileCompatibility, record.AVCLevelIndication))
+		case av.H265:
+			codec := data.(h265parser.CodecData)
+			if len(codec.RecordInfo.SPS) == 0 {
+				continue
+			}
+			info, err := ParseHEVCSPS(codec.RecordInfo.SPS[0])
+			if err != nil {
+				continue
+			}
+			res = append(res, hevcCodecString(info))
+		case av.AAC:
+			res = append(res, "mp4a.40."+strconv.Itoa(int(data.(aacparser.CodecData).Config.ObjectType)))
+		}
+	}
+	return strings.Join(res, ",")
+}
+
+//filterCodecs keep tracks fMP4 can mux, return codecs and client idx to muxer idx map
+func filterCodecs(name string, val []av.CodecData) ([]av.CodecData, map[int8]int8) {
+	var res []av.CodecData
+	idx := make(map[int8]int8)
+	for i, data := range val {
+		switch data.Type() {
+		case av.H265:
+			idx[int8(i)] = int8(len(res))
+			res = append(res, hevcFixRecord(data.(h265parser.CodecData)))
+		case av.H264, av.AAC:
+			idx[int8(i)] = int8(len(res))
+			res = append(res, data)
+		default:
+			log.Println(name, "codec not supported skip track", data.Type())
+		}
+	}
+	return res, idx
+}
+
+//masterTrack first video track or first track segments split on it
+func masterTrack(codecs []av.CodecData) int8 {
+	for i, codec := range codecs {
+		if codec.Type().IsVideo() {
+			return int8(i)
+		}
+	}
+	return 0
+}
+
+//rtpChannels interleaved RTP channel of video and audio same order client setup, -1 none, RTCP next channel
+func rtpChannels(sdpRaw []byte, disableAudio bool) (int, int) {
+	_, medias := sdp.Parse(string(sdpRaw))
+	video, audio := -1, -1
+	var ch int
+	for _, media := range medias {
+		if (media.AVType != "video" && media.AVType != "audio") || (media.AVType == "audio" && disableAudio) {
+			continue
+		}
+		if media.AVType == "video" && video == -1 {
+			video = ch
+		} else if media.AVType == "audio" && audio == -1 {
+			audio = ch
+		}
+		ch += 2
+	}
+	return video, audio
+}
+
+//PendingST unfinished queued work, Wait until idle without poll
+type PendingST struct {
+	mutex sync.Mutex
+	count int
+	idle  chan struct{} //Closed when count back to zero
+}
+
+//Add delta to unfinished work
+func (element *PendingST) Add(delta int) {
+	element.mutex.Lock()
+	defer element.mutex.Unlock()
+	if element.count == 0 && delta > 0 {
+		element.idle = make(chan struct{})
+	}
+	element.count += delta
+	if element.count == 0 && delta < 0 {
+		close(element.idle)
+	}
+}
+
+//Done one work finished
+func (element *PendingST) Done() {
+	element.Add(-1)
+}
+
+//Wait until no work pending or deadline
+func (element *PendingST) Wait(ctx context.Context) error {
+	element.mutex.Lock()
+	if element.count == 0 {
+		element.mutex.Unlock()
+		return nil
+	}
+	idle := element.idle
+	element.mutex.Unlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-idle:
+		return nil
+	}
+}
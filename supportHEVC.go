@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/deepch/vdk/codec/h264parser"
+	"github.com/deepch/vdk/codec/h265parser"
+	"github.com/deepch/vdk/utils/bits"
+)
+
+//HEVCSPSInfo struct
+type HEVCSPSInfo struct {
+	ProfileSpace       uint   //general_profile_space
+	TierFlag           uint   //general_tier_flag
+	ProfileIDC         uint   //general_profile_idc
+	CompatibilityFlags uint32 //general_profile_compatibility_flags
+	ConstraintFlags    uint64 //general constraint indicator 48 bit
+	LevelIDC           uint   //general_level_idc
+	SubLayers          uint   //sps_max_sub_layers_minus1 + 1
+	TemporalIDNested   uint   //sps_temporal_id_nesting_flag
+	ChromaFormat       uint   //chroma_format_idc
+	BitDepthLuma       uint   //bit_depth_luma_minus8
+	BitDepthChroma     uint   //bit_depth_chroma_minus8
+	Width              uint   //Width after conformance window
+	Height             uint   //Height after conformance window
+	FPS                uint   //FPS from vui timing 0 if not present
+}
+
+//hevcIsKeyFrame check IRAP nal type BLA IDR CRA
+func hevcIsKeyFrame(val []byte) bool {
+	//packet data is 4 byte length prefixed
+	if len(val) < 5 {
+		return false
+	}
+	naluType := (val[4] >> 1) & 0x3f
+	return naluType >= h265parser.NAL_UNIT_CODED_SLICE_BLA_W_LP && naluType <= h265parser.NAL_UNIT_RESERVED_IRAP_VCL23
+}
+
+//hevcFixRecord rewrite hvcC general fields from sps, vdk fill it with sps bytes
+func hevcFixRecord(val h265parser.CodecData) h265parser.CodecData {
+	if len(val.Record) < 23 || len(val.RecordInfo.SPS) == 0 {
+		return val
+	}
+	info, err := ParseHEVCSPS(val.RecordInfo.SPS[0])
+	if err != nil {
+		return val
+	}
+	record := make([]byte, len(val.Record))
+	copy(record, val.Record)
+	record[1] = byte(info.ProfileSpace<<6 | info.TierFlag<<5 | info.ProfileIDC&0x1f)
+	binary.BigEndian.PutUint32(record[2:], info.CompatibilityFlags)
+	for i := 0; i < 6; i++ {
+		record[6+i] = byte(info.ConstraintFlags >> uint(40-8*i))
+	}
+	record[12] = byte(info.LevelIDC)
+	record[13] = 0xf0
+	record[14] = 0x00
+	record[15] = 0xfc
+	record[16] = byte(0xfc | info.ChromaFormat&0x03)
+	record[17] = byte(0xf8 | info.BitDepthLuma&0x07)
+	record[18] = byte(0xf8 | info.BitDepthChroma&0x07)
+	record[19] = 0
+	record[20] = 0
+	record[21] = byte(info.SubLayers&0x07<<3 | info.TemporalIDNested&0x01<<2 | 0x03)
+	val.Record = record
+	return val
+}
+
+//hevcCodecString RFC 6381 hvc1 string
+func hevcCodecString(info HEVCSPSInfo) string {
+	var res strings.Builder
+	res.WriteString("hvc1.")
+	if info.ProfileSpace > 0 {
+		res.WriteByte(byte('A' + info.ProfileSpace - 1))
+	}
+	res.WriteString(strconv.Itoa(int(info.ProfileIDC)))
+	//compatibility flags in reverse bit order
+	var compatibility uint32
+	for i := uint(0); i < 32; i++ {
+		compatibility |= ((info.CompatibilityFlags >> i) & 1) << (31 - i)
+	}
+	res.WriteString("." + strconv.FormatUint(uint64(compatibility), 16))
+	if info.TierFlag == 1 {
+		res.WriteString(".H")
+	} else {
+		res.WriteString(".L")
+	}
+	res.WriteString(strconv.Itoa(int(info.LevelIDC)))
+	//constraint bytes trailing zero omitted
+	constraint := make([]byte, 6)
+	last := -1
+	for i := range constraint {
+		constraint[i] = byte(info.ConstraintFlags >> uint(40-8*i))
+		if constraint[i] != 0 {
+			last = i
+		}
+	}
+	for i := 0; i <= last; i++ {
+		res.WriteString("." + strings.ToUpper(strconv.FormatUint(uint64(constraint[i]), 16)))
+	}
+	return res.String()
+}
+
+//ParseHEVCSPS parse sps up to vui timing
+func ParseHEVCSPS(sps []byte) (info HEVCSPSInfo, err error) {
+	if len(sps) < 3 {
+		return info, ErrorStreamHEVCSPSInvalid
+	}
+	r := &bits.GolombBitReader{R: bytes.NewReader(h264parser.RemoveH264orH265EmulationBytes(sps[2:]))}
+	var v uint
+	//sps_video_parameter_set_id
+	if _, err = r.ReadBits(4); err != nil {
+		return
+	}
+	maxSubLayersMinus1, err := r.ReadBits(3)
+	if err != nil {
+		return
+	}
+	info.SubLayers = maxSubLayersMinus1 + 1
+	if info.TemporalIDNested, err = r.ReadBit(); err != nil {
+		return
+	}
+	if err = hevcParsePTL(r, &info, maxSubLayersMinus1); err != nil {
+		return
+	}
+	//sps_seq_parameter_set_id
+	if _, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if info.ChromaFormat, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if info.ChromaFormat == 3 {
+		if _, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+	if info.Width, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if info.Height, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if v, err = r.ReadBit(); err != nil {
+		return
+	}
+	if v == 1 {
+		var crop [4]uint
+		for i := range crop {
+			if crop[i], err = r.ReadExponentialGolombCode(); err != nil {
+				return
+			}
+		}
+		subWidth, subHeight := uint(1), uint(1)
+		switch info.ChromaFormat {
+		case 1:
+			subWidth, subHeight = 2, 2
+		case 2:
+			subWidth = 2
+		}
+		info.Width -= subWidth * (crop[0] + crop[1])
+		info.Height -= subHeight * (crop[2] + crop[3])
+	}
+	if info.BitDepthLuma, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if info.BitDepthChroma, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	log2MaxPocLsbMinus4, err := r.ReadExponentialGolombCode()
+	if err != nil {
+		return
+	}
+	if v, err = r.ReadBit(); err != nil {
+		return
+	}
+	start := maxSubLayersMinus1
+	if v == 1 {
+		start = 0
+	}
+	for i := start; i <= maxSubLayersMinus1; i++ {
+		if err = hevcSkipUE(r, 3); err != nil {
+			return
+		}
+	}
+	//coding block transform block hierarchy depth
+	if err = hevcSkipUE(r, 6); err != nil {
+		return
+	}
+	//scaling_list_enabled_flag
+	if v, err = r.ReadBit(); err != nil {
+		return
+	}
+	if v == 1 {
+		if v, err = r.ReadBit(); err != nil {
+			return
+		}
+		if v == 1 {
+			if err = hevcSkipScalingList(r); err != nil {
+				return
+			}
+		}
+	}
+	//amp_enabled_flag sample_adaptive_offset_enabled_flag
+	if _, err = r.ReadBits(2); err != nil {
+		return
+	}
+	//pcm_enabled_flag
+	if v, err = r.ReadBit(); err != nil {
+		return
+	}
+	if v == 1 {
+		if _, err = r.ReadBits(8); err != nil {
+			return
+		}
+		if err = hevcSkipUE(r, 2); err != nil {
+			return
+		}
+		if _, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+	numShortTermRefPicSets, err := r.ReadExponentialGolombCode()
+	if err != nil {
+		return
+	}
+	if numShortTermRefPicSets > 64 {
+		return info, ErrorStreamHEVCSPSInvalid
+	}
+	numDeltaPocs := make([]uint, numShortTermRefPicSets)
+	for i := uint(0); i < numShortTermRefPicSets; i++ {
+		if numDeltaPocs[i], err = hevcSkipShortTermRefPicSet(r, i, numDeltaPocs); err != nil {
+			return
+		}
+	}
+	//long_term_ref_pics_present_flag
+	if v, err = r.ReadBit(); err != nil {
+		return
+	}
+	if v == 1 {
+		numLongTerm, err := r.ReadExponentialGolombCode()
+		if err != nil {
+			return info, err
+		}
+		for i := uint(0); i < numLongTerm; i++ {
+			if _, err = r.ReadBits(int(log2MaxPocLsbMinus4) + 4 + 1); err != nil {
+				return info, err
+			}
+		}
+	}
+	//sps_temporal_mvp_enabled_flag strong_intra_smoothing_enabled_flag
+	if _, err = r.ReadBits(2); err != nil {
+		return
+	}
+	//vui_parameters_present_flag
+	if v, err = r.ReadBit(); err != nil || v == 0 {
+		return
+	}
+	err = hevcParseVUI(r, &info)
+	return
+}
+
+//hevcParsePTL profile_tier_level
+func hevcParsePTL(r *bits.GolombBitReader, info *HEVCSPSInfo, maxSubLayersMinus1 uint) (err error) {
+	if info.ProfileSpace, err = r.ReadBits(2); err != nil {
+		return
+	}
+	if info.TierFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if info.ProfileIDC, err = r.ReadBits(5); err != nil {
+		return
+	}
+	if info.CompatibilityFlags, err = r.ReadBits32(32); err != nil {
+		return
+	}
+	if info.ConstraintFlags, err = r.ReadBits64(48); err != nil {
+		return
+	}
+	if info.LevelIDC, err = r.ReadBits(8); err != nil {
+		return
+	}
+	profilePresent := make([]uint, maxSubLayersMinus1)
+	levelPresent := make([]uint, maxSubLayersMinus1)
+	for i := uint(0); i < maxSubLayersMinus1; i++ {
+		if profilePresent[i], err = r.ReadBit(); err != nil {
+			return
+		}
+		if levelPresent[i], err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+	if maxSubLayersMinus1 > 0 {
+		if _, err = r.ReadBits(int(2 * (8 - maxSubLayersMinus1))); err != nil {
+			return
+		}
+	}
+	for i := uint(0); i < maxSubLayersMinus1; i++ {
+		if profilePresent[i] == 1 {
+			if _, err = r.ReadBits64(88); err != nil {
+				return
+			}
+		}
+		if levelPresent[i] == 1 {
+			if _, err = r.ReadBits(8); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+//hevcSkipScalingList scaling_list_data
+func hevcSkipScalingList(r *bits.GolombBitReader) error {
+	for sizeID := 0; sizeID < 4; sizeID++ {
+		step := 1
+		if sizeID == 3 {
+			step = 3
+		}
+		for matrixID := 0; matrixID < 6; matrixID += step {
+			predMode, err := r.ReadBit()
+			if err != nil {
+				return err
+			}
+			if predMode == 0 {
+				if _, err = r.ReadExponentialGolombCode(); err != nil {
+					return err
+				}
+				continue
+			}
+			coefNum := 1 << uint(4+(sizeID<<1))
+			if coefNum > 64 {
+				coefNum = 64
+			}
+			if sizeID > 1 {
+				coefNum++
+			}
+			if err = hevcSkipUE(r, coefNum); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//hevcSkipShortTermRefPicSet st_ref_pic_set return NumDeltaPocs
+func hevcSkipShortTermRefPicSet(r *bits.GolombBitReader, idx uint, numDeltaPocs []uint) (uint, error) {
+	var interPrediction uint
+	var err error
+	if idx != 0 {
+		if interPrediction, err = r.ReadBit(); err != nil {
+			return 0, err
+		}
+	}
+	if interPrediction == 1 {
+		//delta_rps_sign abs_delta_rps_minus1
+		if _, err = r.ReadBit(); err != nil {
+			return 0, err
+		}
+		if _, err = r.ReadExponentialGolombCode(); err != nil {
+			return 0, err
+		}
+		var res uint
+		for j := uint(0); j <= numDeltaPocs[idx-1]; j++ {
+			used, err := r.ReadBit()
+			if err != nil {
+				return 0, err
+			}
+			useDelta := uint(1)
+			if used == 0 {
+				if useDelta, err = r.ReadBit(); err != nil {
+					return 0, err
+				}
+			}
+			if used == 1 || useDelta == 1 {
+				res++
+			}
+		}
+		return res, nil
+	}
+	negative, err := r.ReadExponentialGolombCode()
+	if err != nil {
+		return 0, err
+	}
+	positive, err := r.ReadExponentialGolombCode()
+	if err != nil {
+		return 0, err
+	}
+	if negative > 16 || positive > 16 {
+		return 0, ErrorStreamHEVCSPSInvalid
+	}
+	for i := uint(0); i < negative+positive; i++ {
+		//delta_poc_minus1 used_by_curr_pic_flag
+		if _, err = r.ReadExponentialGolombCode(); err != nil {
+			return 0, err
+		}
+		if _, err = r.ReadBit(); err != nil {
+			return 0, err
+		}
+	}
+	return negative + positive, nil
+}
+
+//hevcParseVUI vui_parameters up to timing info
+func hevcParseVUI(r *bits.GolombBitReader, info *HEVCSPSInfo) (err error) {
+	var v uint
+	//aspect_ratio_info_present_flag
+	if v, err = r.ReadBit(); err != nil {
+		return
+	}
+	if v == 1 {
+		if v, err = r.ReadBits(8); err != nil {
+			return
+		}
+		if v == 255 {
+			if _, err = r.ReadBits(32); err != nil {
+				return
+			}
+		}
+	}
+	//overscan_info_present_flag
+	if v, err = r.ReadBit(); err != nil {
+		return
+	}
+	if v == 1 {
+		if _, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+	//video_signal_type_present_flag
+	if v, err = r.ReadBit(); err != nil {
+		return
+	}
+	if v == 1 {
+		if _, err = r.ReadBits(4); err != nil {
+			return
+		}
+		if v, err = r.ReadBit(); err != nil {
+			return
+		}
+		if v == 1 {
+			if _, err = r.ReadBits(24); err != nil {
+				return
+			}
+		}
+	}
+	//chroma_loc_info_present_flag
+	if v, err = r.ReadBit(); err != nil {
+		return
+	}
+	if v == 1 {
+		if err = hevcSkipUE(r, 2); err != nil {
+			return
+		}
+	}
+	//neutral_chroma field_seq frame_field_info_present
+	if _, err = r.ReadBits(3); err != nil {
+		return
+	}
+	//default_display_window_flag
+	if v, err = r.ReadBit(); err != nil {
+		return
+	}
+	if v == 1 {
+		if err = hevcSkipUE(r, 4); err != nil {
+			return
+		}
+	}
+	//vui_timing_info_present_flag
+	if v, err = r.ReadBit(); err != nil || v == 0 {
+		return
+	}
+	numUnitsInTick, err := r.ReadBits32(32)
+	if err != nil {
+		return
+	}
+	timeScale, err := r.ReadBits32(32)
+	if err != nil {
+		return
+	}
+	if numUnitsInTick > 0 {
+		info.FPS = uint(timeScale / numUnitsInTick)
+	}
+	return
+}
+
+//hevcSkipUE skip count exp golomb values
+func hevcSkipUE(r *bits.GolombBitReader, count int) error {
+	for i := 0; i < count; i++ {
+		if _, err := r.ReadExponentialGolombCode(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
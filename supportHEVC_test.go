@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+//1080p main, coded 1088 cropped to 1080, vui 25 fps
+var testHEVCSPSMain = []byte{0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x78, 0xa0, 0x03, 0xc0, 0x80, 0x11, 0x07, 0xcb, 0x96, 0x57, 0x92, 0x4d, 0x92, 0xee, 0x01, 0x00, 0x00, 0x03, 0x00, 0x01, 0x00, 0x00, 0x03, 0x00, 0x19, 0x20}
+
+//2160p main10 high tier, two sub layers, no vui
+var testHEVCSPSMain10 = []byte{0x42, 0x01, 0x02, 0x22, 0x20, 0x00, 0x00, 0x03, 0x00, 0xb0, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x99, 0x00, 0x00, 0xa0, 0x01, 0xe0, 0x20, 0x02, 0x1c, 0x4d, 0x96, 0x57, 0x2b, 0xc9, 0x26, 0xc9, 0x76, 0x80}
+
+func TestParseHEVCSPS(t *testing.T) {
+	info, err := ParseHEVCSPS(testHEVCSPSMain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := HEVCSPSInfo{ProfileIDC: 1, CompatibilityFlags: 0x60000000, ConstraintFlags: 0x900000000000, LevelIDC: 120, SubLayers: 1, TemporalIDNested: 1, ChromaFormat: 1, Width: 1920, Height: 1080, FPS: 25}
+	if info != want {
+		t.Errorf("main %+v want %+v", info, want)
+	}
+	info, err = ParseHEVCSPS(testHEVCSPSMain10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = HEVCSPSInfo{ProfileIDC: 2, TierFlag: 1, CompatibilityFlags: 0x20000000, ConstraintFlags: 0xb00000000000, LevelIDC: 153, SubLayers: 2, ChromaFormat: 1, BitDepthLuma: 2, BitDepthChroma: 2, Width: 3840, Height: 2160}
+	if info != want {
+		t.Errorf("main10 %+v want %+v", info, want)
+	}
+	//truncated sps must fail not panic
+	for _, size := range []int{0, 2, 12, 20} {
+		if _, err := ParseHEVCSPS(testHEVCSPSMain[:size]); err == nil {
+			t.Errorf("truncated %d no error", size)
+		}
+	}
+}
+
+func TestHEVCCodecString(t *testing.T) {
+	tests := map[string]HEVCSPSInfo{
+		"hvc1.1.6.L93.90":      {ProfileIDC: 1, CompatibilityFlags: 0x60000000, ConstraintFlags: 0x900000000000, LevelIDC: 93},
+		"hvc1.2.4.L120.90":     {ProfileIDC: 2, CompatibilityFlags: 0x20000000, ConstraintFlags: 0x900000000000, LevelIDC: 120},
+		"hvc1.1.6.H153.B0":     {ProfileIDC: 1, TierFlag: 1, CompatibilityFlags: 0x60000000, ConstraintFlags: 0xb00000000000, LevelIDC: 153},
+		"hvc1.A1.2.L93":        {ProfileSpace: 1, ProfileIDC: 1, CompatibilityFlags: 0x40000000, LevelIDC: 93},
+		"hvc1.4.10.L90.90.0.1": {ProfileIDC: 4, CompatibilityFlags: 0x08000000, ConstraintFlags: 0x900001000000, LevelIDC: 90},
+	}
+	for want, info := range tests {
+		if got := hevcCodecString(info); got != want {
+			t.Errorf("%+v got %s want %s", info, got, want)
+		}
+	}
+}
+
+func TestHEVCIsKeyFrame(t *testing.T) {
+	//nal type in bits 1-6 of first byte after 4 byte length
+	for naluType, want := range map[byte]bool{1: false, 16: true, 19: true, 20: true, 21: true, 23: true, 24: false, 32: false} {
+		if got := hevcIsKeyFrame([]byte{0, 0, 0, 2, naluType << 1, 1}); got != want {
+			t.Errorf("nal type %d key %v want %v", naluType, got, want)
+		}
+	}
+	if hevcIsKeyFrame([]byte{0, 0, 0, 1}) {
+		t.Error("short packet key")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const TimelineMaxRange = 366 * 24 * time.Hour //max timeline window
+
+//TimelineST recorded ranges, gaps and media changes in window
+type TimelineST struct {
+	From    time.Time          `json:"from"`
+	To      time.Time          `json:"to"`
+	Ranges  []TimelineRangeST  `json:"ranges"`
+	Gaps    []TimelineGapST    `json:"gaps"`
+	Changes []TimelineChangeST `json:"changes"`
+}
+
+//TimelineMediaST codecs and video size, empty for footage indexed from file names
+type TimelineMediaST struct {
+	Codecs string `json:"codecs,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+//TimelineRangeST contiguous recording, media of first segment
+type TimelineRangeST struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Segments int       `json:"segments"`
+	Size     int64     `json:"size"`
+	TimelineMediaST
+}
+
+//TimelineGapST window part without footage
+type TimelineGapST struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+//TimelineChangeST codecs or resolution change at segment start
+type TimelineChangeST struct {
+	Time time.Time       `json:"time"`
+	From TimelineMediaST `json:"from"`
+	To   TimelineMediaST `json:"to"`
+}
+
+//Timeline from day indexes, ranges clipped to window, gaps fill rest of window
+func (element *ConfigST) Timeline(uuid string, from time.Time, to time.Time) (*TimelineST, error) {
+	if !to.After(from) || to.Sub(from) > TimelineMaxRange {
+		return nil, ErrorStreamArchiveRange
+	}
+	root, err := element.recordRoot(uuid)
+	if err != nil {
+		return nil, err
+	}
+	//only existing day dirs, window may cover a year of missing days
+	days, err := ioutil.ReadDir(filepath.Join(root, uuid))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var entries []RecordEntryST
+	//segment in dir of start day may cross midnight into from
+	first, last := from.Add(-24*time.Hour).UTC().Format(RecordDateLayout), to.UTC().Format(RecordDateLayout)
+	for _, day := range days {
+		if !day.IsDir() || day.Name() < first || day.Name() > last {
+			continue
+		}
+		tmp, err := recordIndex.Read(filepath.Join(root, uuid, day.Name()))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, entry := range tmp {
+			if entry.End().After(from) && entry.Start().Before(to) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	res := &TimelineST{From: from, To: to, Ranges: []TimelineRangeST{}, Gaps: []TimelineGapST{}, Changes: []TimelineChangeST{}}
+	for i, entry := range entries {
+		media := TimelineMediaST{Codecs: entry.Codecs, Width: entry.Width, Height: entry.Height}
+		if i > 0 {
+			prev := entries[i-1]
+			if prevMedia := (TimelineMediaST{Codecs: prev.Codecs, Width: prev.Width, Height: prev.Height}); prev.Codecs != "" && entry.Codecs != "" && prevMedia != media {
+				res.Changes = append(res.Changes, TimelineChangeST{Time: entry.Start(), From: prevMedia, To: media})
+			}
+		}
+		n := len(res.Ranges)
+		if n > 0 && entry.Start().Sub(res.Ranges[n-1].End) <= ArchiveGap {
+			if entry.End().After(res.Ranges[n-1].End) {
+				res.Ranges[n-1].End = entry.End()
+			}
+			res.Ranges[n-1].Segments++
+			res.Ranges[n-1].Size += entry.Size
+			continue
+		}
+		res.Ranges = append(res.Ranges, TimelineRangeST{Start: entry.Start(), End: entry.End(), Segments: 1, Size: entry.Size, TimelineMediaST: media})
+	}
+	start := from
+	for i := range res.Ranges {
+		if res.Ranges[i].Start.Before(from) {
+			res.Ranges[i].Start = from
+		}
+		if res.Ranges[i].End.After(to) {
+			res.Ranges[i].End = to
+		}
+		if res.Ranges[i].Start.After(start) {
+			res.Gaps = append(res.Gaps, TimelineGapST{Start: start, End: res.Ranges[i].Start})
+		}
+		start = res.Ranges[i].End
+	}
+	if to.After(start) {
+		res.Gaps = append(res.Gaps, TimelineGapST{Start: start, End: to})
+	}
+	return res, nil
+}
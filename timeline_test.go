@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//testTimelineConfig stream cam with day indexes of entries
+func testTimelineConfig(t *testing.T, entries []RecordEntryST) *ConfigST {
+	t.Helper()
+	root := t.TempDir()
+	for _, entry := range entries {
+		dir := filepath.Join(root, "cam", entry.Start().UTC().Format(RecordDateLayout))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		line, err := json.Marshal(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadFile(filepath.Join(dir, RecordIndexFile))
+		if err = ioutil.WriteFile(filepath.Join(dir, RecordIndexFile), append(append(data, line...), '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &ConfigST{Streams: map[string]StreamST{"cam": {RecordPath: root}}}
+}
+
+//testTimelineEntry entry at offset from midnight
+func testTimelineEntry(offset time.Duration, duration time.Duration, codecs string, width int) RecordEntryST {
+	start := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC).Add(offset)
+	return RecordEntryST{Time: start.UnixNano() / int64(time.Millisecond), Duration: duration.Milliseconds(), Init: "a", Size: 100, Codecs: codecs, Width: width}
+}
+
+func TestTimeline(t *testing.T) {
+	midnight := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	config := testTimelineConfig(t, []RecordEntryST{
+		//crosses midnight from previous day dir
+		testTimelineEntry(-time.Second, 2*time.Second, "avc1.64001f", 1280),
+		testTimelineEntry(time.Second, 2*time.Second, "avc1.64001f", 1280),
+		//within ArchiveGap same range
+		testTimelineEntry(3500*time.Millisecond, 2*time.Second, "avc1.64001f", 1280),
+		testTimelineEntry(time.Minute, 2*time.Second, "avc1.64001f", 1920),
+		//indexed from file name no media, no change
+		testTimelineEntry(time.Minute+2*time.Second, 2*time.Second, "", 0),
+	})
+	res, err := config.Timeline("cam", midnight, midnight.Add(2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Ranges) != 2 || len(res.Gaps) != 2 || len(res.Changes) != 1 {
+		t.Fatalf("timeline %+v", res)
+	}
+	first, second := res.Ranges[0], res.Ranges[1]
+	if !first.Start.Equal(midnight) || !first.End.Equal(midnight.Add(5500*time.Millisecond)) || first.Segments != 3 || first.Size != 300 || first.Width != 1280 {
+		t.Errorf("first %+v", first)
+	}
+	if !second.Start.Equal(midnight.Add(time.Minute)) || !second.End.Equal(midnight.Add(time.Minute+4*time.Second)) || second.Segments != 2 || second.Width != 1920 {
+		t.Errorf("second %+v", second)
+	}
+	if !res.Gaps[0].Start.Equal(first.End) || !res.Gaps[0].End.Equal(second.Start) || !res.Gaps[1].Start.Equal(second.End) || !res.Gaps[1].End.Equal(midnight.Add(2*time.Minute)) {
+		t.Errorf("gaps %+v", res.Gaps)
+	}
+	if change := res.Changes[0]; !change.Time.Equal(second.Start) || change.From.Width != 1280 || change.To.Width != 1920 {
+		t.Errorf("change %+v", change)
+	}
+	//clipped to window
+	res, err = config.Timeline("cam", midnight.Add(2*time.Second), midnight.Add(time.Minute+time.Second))
+	if err != nil || len(res.Ranges) != 2 || !res.Ranges[0].Start.Equal(midnight.Add(2*time.Second)) || !res.Ranges[1].End.Equal(midnight.Add(time.Minute+time.Second)) || res.Ranges[0].Segments != 2 {
+		t.Errorf("clipped %+v %v", res, err)
+	}
+	//no footage one gap, empty lists not null
+	res, err = config.Timeline("cam", midnight.Add(time.Hour), midnight.Add(2*time.Hour))
+	if err != nil || len(res.Gaps) != 1 || res.Ranges == nil || res.Changes == nil {
+		t.Errorf("empty %+v %v", res, err)
+	}
+}
+
+func TestTimelineDays(t *testing.T) {
+	config := testTimelineConfig(t, []RecordEntryST{testTimelineEntry(0, 2*time.Second, "avc1.64001f", 1280)})
+	root := config.Streams["cam"].RecordPath
+	//not day dirs and files skipped
+	if err := os.MkdirAll(filepath.Join(root, "cam", "tmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "cam", "2026-10-19"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	//full year window read existing day only
+	to := time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)
+	res, err := config.Timeline("cam", to.Add(-TimelineMaxRange), to)
+	if err != nil || len(res.Ranges) != 1 || res.Ranges[0].Segments != 1 {
+		t.Errorf("year %+v %v", res, err)
+	}
+	//stream without footage dir
+	config.Streams["empty"] = StreamST{RecordPath: root}
+	if res, err = config.Timeline("empty", to.Add(-time.Hour), to); err != nil || len(res.Ranges) != 0 || len(res.Gaps) != 1 {
+		t.Errorf("no dir %+v %v", res, err)
+	}
+}
+
+func TestTimelineRange(t *testing.T) {
+	config := testTimelineConfig(t, nil)
+	now := time.Now()
+	for name, val := range map[string][2]time.Time{
+		"end before start": {now, now.Add(-time.Second)},
+		"empty window":     {now, now},
+		"over max range":   {now.Add(-TimelineMaxRange - time.Second), now},
+	} {
+		if _, err := config.Timeline("cam", val[0], val[1]); err != ErrorStreamArchiveRange {
+			t.Errorf("%s %v", name, err)
+		}
+	}
+	if _, err := config.Timeline("missing", now.Add(-time.Hour), now); err != ErrorStreamNotFound {
+		t.Errorf("unknown stream %v", err)
+	}
+}
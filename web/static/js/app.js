@@ -3,7 +3,7 @@ $(document).ready(function() {
   var suuid = $('#suuid').val();
   $('#'+suuid).addClass('active');
   var video = document.getElementById('livestream');
-  var videoSrc = '/play/hls/'+suuid+'/index.m3u8';
+  var videoSrc = '/play/hls/'+suuid+'/master.m3u8';
   if (Hls.isSupported() && !bw.safari) {
     var hls = new Hls({
       autoStartLoad: true,
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	WebhookEventOnline      = "online"
+	WebhookEventOffline     = "offline"
+	WebhookEventCodecChange = "codec_change"
+	WebhookEventNoVideo     = "no_video"
+	WebhookEventFailover    = "failover"
+)
+
+const (
+	WebhookQueueSize   = 1024 //pending events and deliveries, full queue drop
+	WebhookWorkers     = 2
+	WebhookMaxAttempts = 5
+	WebhookTimeout     = 5 * time.Second
+	WebhookSignature   = "X-Webhook-Signature" //sha256=hex HMAC of timestamp.body with secret
+	WebhookTimestamp   = "X-Webhook-Timestamp" //unix seconds of delivery attempt, signed with body
+	WebhookSecretMask  = "********"            //secret in api response, on update keep stored secret
+)
+
+//webhookEvents known event names
+var webhookEvents = []string{WebhookEventOnline, WebhookEventOffline, WebhookEventCodecChange, WebhookEventNoVideo, WebhookEventFailover}
+
+//webhookBackoff delivery retry policy
+var webhookBackoff = BackoffST{Initial: time.Second, Multiplier: 2, Max: 30 * time.Second, Jitter: 0.2}
+
+//Webhook global delivery queue
+var Webhook = NewWebhookQueue()
+
+//WebhookST callback target
+type WebhookST struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+//webhooksPublic copy with masked secrets for api
+func webhooksPublic(hooks []WebhookST) []WebhookST {
+	if hooks == nil {
+		return nil
+	}
+	res := make([]WebhookST, len(hooks))
+	for i, hook := range hooks {
+		if hook.Secret != "" {
+			hook.Secret = WebhookSecretMask
+		}
+		res[i] = hook
+	}
+	return res
+}
+
+//webhooksRestore replace masked secrets with stored secret of same url
+func webhooksRestore(hooks []WebhookST, old []WebhookST) []WebhookST {
+	var res []WebhookST
+	for _, hook := range hooks {
+		if hook.Secret == WebhookSecretMask {
+			hook.Secret = ""
+			for _, tmp := range old {
+				if tmp.URL == hook.URL {
+					hook.Secret = tmp.Secret
+					break
+				}
+			}
+		}
+		res = append(res, hook)
+	}
+	return res
+}
+
+//WebhookEventST callback json payload
+type WebhookEventST struct {
+	Event  string          `json:"event"`
+	Stream string          `json:"stream"`
+	Time   time.Time       `json:"time"`
+	State  string          `json:"state,omitempty"`
+	Source int             `json:"source"`
+	Reason string          `json:"reason,omitempty"`
+	Codecs []CodecStatusST `json:"codecs,omitempty"`
+}
+
+//WebhookQueueST resolve targets and deliver with retry
+type WebhookQueueST struct {
+	pending PendingST //events and deliveries not finished
+	events  chan WebhookEventST
+	jobs    chan *webhookJob
+	client  *http.Client
+}
+
+//webhookJob one delivery to one target
+type webhookJob struct {
+	hook    WebhookST
+	event   string
+	body    []byte
+	attempt int
+}
+
+//NewWebhookQueue start dispatcher and delivery workers
+func NewWebhookQueue() *WebhookQueueST {
+	res := &WebhookQueueST{
+		events: make(chan WebhookEventST, WebhookQueueSize),
+		jobs:   make(chan *webhookJob, WebhookQueueSize),
+		client: &http.Client{Timeout: WebhookTimeout},
+	}
+	go res.dispatch()
+	for i := 0; i < WebhookWorkers; i++ {
+		go res.deliver()
+	}
+	return res
+}
+
+//validate url and event names
+func (element *WebhookST) validate() error {
+	tmp, err := url.Parse(element.URL)
+	if err != nil || (tmp.Scheme != "http" && tmp.Scheme != "https") || tmp.Host == "" {
+		return ErrorStreamWebhookInvalid
+	}
+	for _, event := range element.Events {
+		if !stringInSlice(event, webhookEvents) {
+			return ErrorStreamWebhookInvalid
+		}
+	}
+	return nil
+}
+
+//match target want event, empty events all
+func (element *WebhookST) match(event string) bool {
+	return len(element.Events) == 0 || stringInSlice(event, element.Events)
+}
+
+//sign HMAC-SHA256 of timestamp dot body, replayed body with new timestamp fail
+func (element *WebhookST) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(element.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//webhooks global and stream targets for event
+func (element *ConfigST) webhooks(uuid string, event string) []WebhookST {
+	element.mutex.RLock()
+	defer element.mutex.RUnlock()
+	var res []WebhookST
+	for _, hook := range append(append([]WebhookST{}, element.Server.Webhooks...), element.Streams[uuid].Webhooks...) {
+		if hook.match(event) {
+			res = append(res, hook)
+		}
+	}
+	return res
+}
+
+//Event queue event never block caller, safe under any lock
+func (element *WebhookQueueST) Event(event WebhookEventST) {
+	event.Time = time.Now()
+	element.pending.Add(1)
+	select {
+	case element.events <- event:
+	default:
+		element.pending.Done()
+		log.Println(event.Stream, "Webhook Queue Full Drop", event.Event)
+	}
+}
+
+//dispatch resolve targets and build payload
+func (element *WebhookQueueST) dispatch() {
+	for event := range element.events {
+		hooks := Config.webhooks(event.Stream, event.Event)
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Println(event.Stream, "Webhook Marshal Error", err)
+			hooks = nil
+		}
+		for _, hook := range hooks {
+			element.pending.Add(1)
+			element.queue(&webhookJob{hook: hook, event: event.Event, body: body})
+		}
+		element.pending.Done()
+	}
+}
+
+//queue delivery, full queue drop
+func (element *WebhookQueueST) queue(job *webhookJob) {
+	select {
+	case element.jobs <- job:
+	default:
+		element.pending.Done()
+		log.Println("Webhook Queue Full Drop", job.hook.URL, job.event)
+	}
+}
+
+//deliver post jobs retry failed with backoff
+func (element *WebhookQueueST) deliver() {
+	for job := range element.jobs {
+		job.attempt++
+		err := element.post(job)
+		if err == nil {
+			element.pending.Done()
+			continue
+		}
+		if job.attempt >= WebhookMaxAttempts {
+			log.Println("Webhook Delivery Failed Drop", job.hook.URL, job.event, err)
+			element.pending.Done()
+			continue
+		}
+		log.Println("Webhook Delivery Error Retry", job.hook.URL, job.event, err)
+		retry := job
+		time.AfterFunc(webhookBackoff.Delay(job.attempt), func() {
+			element.queue(retry)
+		})
+	}
+}
+
+//post one delivery, not 2xx is error
+func (element *WebhookQueueST) post(job *webhookJob) error {
+	req, err := http.NewRequest(http.MethodPost, job.hook.URL, bytes.NewReader(job.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", job.event)
+	//retry sign again with own attempt time
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(WebhookTimestamp, timestamp)
+	if job.hook.Secret != "" {
+		req.Header.Set(WebhookSignature, job.hook.sign(timestamp, job.body))
+	}
+	res, err := element.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Webhook Status %s", res.Status)
+	}
+	return nil
+}
+
+//Flush wait queued events and retries or deadline
+func (element *WebhookQueueST) Flush(ctx context.Context) error {
+	return element.pending.Wait(ctx)
+}
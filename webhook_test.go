@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookSign(t *testing.T) {
+	hook := WebhookST{URL: "http://127.0.0.1/hook", Secret: "key"}
+	if sign := hook.sign("1700000000", []byte(`{"event":"online"}`)); sign != "sha256=111b65cc658cf693a20608ed9c0a0537703f906efdea080aad21067119a51db9" {
+		t.Errorf("sign %s", sign)
+	}
+	//same body other time other signature
+	if hook.sign("1700000001", []byte(`{"event":"online"}`)) == hook.sign("1700000000", []byte(`{"event":"online"}`)) {
+		t.Error("timestamp not signed")
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	old := webhookBackoff
+	webhookBackoff = BackoffST{Initial: 10 * time.Millisecond, Multiplier: 2, Max: 50 * time.Millisecond}
+	t.Cleanup(func() {
+		webhookBackoff = old
+	})
+	var mutex sync.Mutex
+	var requests []*http.Request
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		//first two attempts fail, third accepted
+		if len(requests) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	testStream(t, "test-webhook", StreamST{URL: "rtsp://127.0.0.1:1/test", Webhooks: []WebhookST{{URL: server.URL, Secret: "key", Events: []string{WebhookEventOnline}}}})
+	queue := NewWebhookQueue()
+	queue.Event(WebhookEventST{Event: WebhookEventOffline, Stream: "test-webhook"})
+	queue.Event(WebhookEventST{Event: WebhookEventOnline, Stream: "test-webhook", State: StreamStateOnline})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := queue.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	//filtered event never sent, failed retried with same body
+	if len(requests) != 3 {
+		t.Fatalf("requests %d want 3", len(requests))
+	}
+	var event WebhookEventST
+	if err := json.Unmarshal(bodies[2], &event); err != nil || event.Event != WebhookEventOnline || event.Stream != "test-webhook" {
+		t.Errorf("payload %s %v", bodies[2], err)
+	}
+	for i, req := range requests {
+		timestamp := req.Header.Get(WebhookTimestamp)
+		sec, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(sec, 0)) > time.Minute {
+			t.Errorf("request %d timestamp %q", i, timestamp)
+		}
+		//receiver side check
+		mac := hmac.New(sha256.New, []byte("key"))
+		mac.Write([]byte(timestamp + "." + string(bodies[i])))
+		if req.Header.Get(WebhookSignature) != "sha256="+hex.EncodeToString(mac.Sum(nil)) || req.Header.Get("X-Webhook-Event") != WebhookEventOnline {
+			t.Errorf("request %d headers %v", i, req.Header)
+		}
+		if string(bodies[i]) != string(bodies[0]) {
+			t.Errorf("request %d body changed", i)
+		}
+	}
+}
+
+func TestWebhookDrop(t *testing.T) {
+	old := webhookBackoff
+	webhookBackoff = BackoffST{Initial: time.Millisecond, Multiplier: 1, Max: time.Millisecond}
+	t.Cleanup(func() {
+		webhookBackoff = old
+	})
+	var mutex sync.Mutex
+	var count int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		count++
+		mutex.Unlock()
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	testStream(t, "test-webhook-drop", StreamST{URL: "rtsp://127.0.0.1:1/test", Webhooks: []WebhookST{{URL: server.URL}}})
+	queue := NewWebhookQueue()
+	queue.Event(WebhookEventST{Event: WebhookEventNoVideo, Stream: "test-webhook-drop"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := queue.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if count != WebhookMaxAttempts {
+		t.Errorf("attempts %d want %d", count, WebhookMaxAttempts)
+	}
+}
+
+func TestWebhooksMask(t *testing.T) {
+	stored := []WebhookST{{URL: "http://a/hook", Secret: "one"}, {URL: "http://b/hook"}}
+	public := webhooksPublic(stored)
+	if public[0].Secret != WebhookSecretMask || public[1].Secret != "" || stored[0].Secret != "one" {
+		t.Fatalf("public %+v", public)
+	}
+	//masked put back keep stored, new secret replace, unknown url masked drop
+	res := webhooksRestore([]WebhookST{public[0], {URL: "http://b/hook", Secret: "two"}, {URL: "http://c/hook", Secret: WebhookSecretMask}}, stored)
+	if res[0].Secret != "one" || res[1].Secret != "two" || res[2].Secret != "" {
+		t.Errorf("restore %+v", res)
+	}
+}